@@ -0,0 +1,201 @@
+package snakes
+
+import (
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// ArenaConfig is the configuration for running games with RunArena.
+type ArenaConfig struct {
+	// Arena dimensions. If unset, the arena is sized from the number of
+	// players (see ArenaSize).
+	Width, Height int
+	// Initial length of each snake. If unset, 5 is used.
+	InitialSnakeLength int
+	// Rules used for each game. If nil, ClassicRuleset is used.
+	Ruleset Ruleset
+	// Strategy used to place the snakes. If nil, LineSpawn is used.
+	Spawn SpawnStrategy
+	// Items kept in the arena in addition to the apple.
+	Items []ItemConfig
+	// Policy used to place the apple and items. If nil, UniformPlacement is
+	// used.
+	Placement PlacementPolicy
+	// Map to play on. If set, the map's size, walls, spawn points and apple
+	// zones are used instead of the arena size and spawn strategy above.
+	Map *Map
+	// Maximum number of ticks in a game. If a game reaches the limit, the
+	// longest snake wins. If unset, there is no limit.
+	MaxTicks int
+	// Number of games to play.
+	Games int
+	// Number of games to play at the same time. If unset, runtime.NumCPU()
+	// is used.
+	Parallelism int
+	// Seed of the first game. Game i uses Seed+i.
+	Seed int64
+}
+
+// ArenaPlayer is a bot that takes part in arena games.
+type ArenaPlayer struct {
+	// Name of the player. Names must be unique between players.
+	Name string
+	// NewStrategy returns the bot's strategy for a single game. A new
+	// strategy is created for every game, so strategies may keep state
+	// between turns. seed is derived from the game's seed, and should be
+	// used for any random decisions so that games can be reproduced.
+	NewStrategy func(seed int64) Strategy
+}
+
+// ArenaPlayerResult contains the game results of a single player.
+type ArenaPlayerResult struct {
+	Name   string
+	Wins   int
+	Draws  int
+	Losses int
+}
+
+// ArenaResult contains the results of running games with RunArena.
+type ArenaResult struct {
+	Games int
+	// Results of each player, in the order that the players were given
+	// to RunArena.
+	Players []ArenaPlayerResult
+}
+
+// RunArena plays games between the given players in-process, without a
+// Server or any delay between ticks. An error is returned if a game can not
+// be started, such as when there is not enough space in the arena for every
+// player.
+//
+// The function panics if fewer than two players are given.
+func RunArena(cfg ArenaConfig, players []ArenaPlayer) (*ArenaResult, error) {
+	if len(players) < 2 {
+		panic("len(players) < 2")
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		cfg.Width, cfg.Height = ArenaSize(len(players), 0)
+	}
+	if cfg.InitialSnakeLength <= 0 {
+		cfg.InitialSnakeLength = 5
+	}
+	parallelism := cfg.Parallelism
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+
+	result := &ArenaResult{
+		Games:   cfg.Games,
+		Players: make([]ArenaPlayerResult, len(players)),
+	}
+	for i, player := range players {
+		result.Players[i].Name = player.Name
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	games := make(chan int)
+
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for game := range games {
+				winner, err := playArenaGame(cfg, cfg.Seed+int64(game), players)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+				for i := range result.Players {
+					switch {
+					case winner < 0:
+						result.Players[i].Draws++
+					case winner == i:
+						result.Players[i].Wins++
+					default:
+						result.Players[i].Losses++
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for game := 0; game < cfg.Games; game++ {
+		games <- game
+	}
+	close(games)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+// playArenaGame plays a single game and returns the index of the winning
+// player, or -1 if there was no winner.
+func playArenaGame(cfg ArenaConfig, seed int64, players []ArenaPlayer) (int, error) {
+	strategySeeds := make([]int64, len(players))
+	rng := rand.New(rand.NewSource(seed))
+	for i := range strategySeeds {
+		strategySeeds[i] = rng.Int63()
+	}
+
+	order := SpawnOrder(seed, len(players))
+	names := make([]string, len(players))
+	strategies := make([]Strategy, len(players))
+	for i, idx := range order {
+		names[i] = players[idx].Name
+		strategies[i] = players[idx].NewStrategy(strategySeeds[idx])
+	}
+
+	stateConfig := StateConfig{
+		Width:              cfg.Width,
+		Height:             cfg.Height,
+		SnakeCount:         len(players),
+		InitialSnakeLength: cfg.InitialSnakeLength,
+		Ruleset:            cfg.Ruleset,
+		Spawn:              cfg.Spawn,
+		Items:              cfg.Items,
+		Placement:          cfg.Placement,
+		Seed:               seed,
+	}
+	if cfg.Map != nil {
+		cfg.Map.Apply(&stateConfig)
+	}
+	state, err := NewState(stateConfig)
+	if err != nil {
+		return 0, err
+	}
+	directions := make([]Direction, len(players))
+
+	for tick := 0; cfg.MaxTicks <= 0 || tick < cfg.MaxTicks; tick++ {
+		msg := roundStateMessageFromState(names, state)
+		for i, strategy := range strategies {
+			if state.Snakes[i].Alive {
+				directions[i] = strategy.Decide(msg, names[i])
+			}
+		}
+
+		state = state.Next(directions)
+		if completed, winner := state.IsCompleted(); completed {
+			if winner < 0 {
+				return -1, nil
+			}
+			return order[winner], nil
+		}
+	}
+
+	if winner, ok := state.LongestSnake(); ok {
+		return order[winner], nil
+	}
+	return -1, nil
+}
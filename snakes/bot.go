@@ -1,11 +1,13 @@
 package snakes
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -13,65 +15,181 @@ import (
 // WebSocketBot is the client-side for the WebSocket server.
 // It provides a helpful abstraction over the game rounds and turns.
 type WebSocketBot struct {
-	c    *websocket.Conn
-	name string
+	addr    string
+	headers http.Header
+	name    string
+	ctx     context.Context
 
 	rounds chan *BotRound
+	// done is closed once the reader returns.
+	done chan struct{}
 
-	mu  sync.Mutex
-	err error
+	// Backoff of the next reconnection attempt, and when the bot started
+	// reconnecting. Both are reset once a message is received from the
+	// server. Only used by the reader.
+	reconnectWait  time.Duration
+	reconnectStart time.Time
+
+	mu      sync.Mutex
+	c       *wsConn
+	session string
+	closed  bool
+	err     error
+	acks    chan *MoveAckMessage
 }
 
+// Maximum amount of time to spend establishing a connection to the server.
+const dialTimeout = time.Second * 10
+
+// Reconnection backoff parameters.
+const (
+	reconnectMinWait = time.Millisecond * 100
+	reconnectMaxWait = time.Second * 2
+	reconnectTimeout = time.Second * 10
+)
+
 // NewWebSocketBot establishes a new bot connection to the given server address
 // and uses botName as the bot's identifier.
 //
+// A specific room on the server can be joined by adding a room query
+// parameter to addr (e.g. ws://127.0.0.1:8080/ws?room=practice). If the
+// server requires an API token for the bot, it is passed with a token
+// query parameter.
+//
+// Messages from the server are received in the compact binary encoding if
+// the server supports it (see BinarySubprotocol), and round states are
+// received as deltas (see RoundDeltaMessage). Each BotTurn still contains the
+// full RoundStateMessage.
+//
+// If the connection to the server is lost, the bot reconnects with backoff
+// and resumes control of its snake, provided the server supports sessions.
+// The server is pinged periodically, so that a dead connection is detected
+// even while no messages are being sent.
+//
 // nil and an error is returned if there was a problem establishing the connection.
 func NewWebSocketBot(addr, botName string) (*WebSocketBot, error) {
-	var dialer websocket.Dialer
+	return DialWebSocketBot(context.Background(), addr, botName)
+}
 
+// DialWebSocketBot is like NewWebSocketBot, but establishes the connection
+// using ctx. Once the bot is connected, cancelling ctx closes the bot, as if
+// Close was called, and Err returns ctx.Err().
+func DialWebSocketBot(ctx context.Context, addr, botName string) (*WebSocketBot, error) {
 	headers := make(http.Header)
 	headers.Set("X-Snake-Name", botName)
+	headers.Set("X-Snake-Deltas", "true")
 
-	conn, _, err := dialer.Dial(addr, headers)
+	conn, _, err := dial(ctx, addr, headers)
 	if err != nil {
 		return nil, err
 	}
 
 	bot := &WebSocketBot{
-		c:    conn,
-		name: botName,
+		addr:    addr,
+		headers: headers,
+		c:       newWSConn(conn),
+		name:    botName,
+		ctx:     ctx,
 
-		rounds: make(chan *BotRound),
+		rounds: make(chan *BotRound, 1),
+		done:   make(chan struct{}),
 	}
 	go bot.reader()
+	go func() {
+		select {
+		case <-ctx.Done():
+			bot.Close()
+		case <-bot.done:
+		}
+	}()
 
 	return bot, nil
 }
 
+// dial establishes a WebSocket connection to the server, giving up after
+// dialTimeout.
+func dial(ctx context.Context, addr string, headers http.Header) (*websocket.Conn, *http.Response, error) {
+	dialer := websocket.Dialer{
+		Subprotocols:     Subprotocols,
+		HandshakeTimeout: dialTimeout,
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	return dialer.DialContext(ctx, addr, headers)
+}
+
 // reader is the background reader for the bot. It is spawn from NewWebSocketBot and is alive
 // until the connection is closed.
+//
+// The reader never blocks on the user: if a round or turn has not been
+// received by the time the next one arrives, it is replaced.
 func (w *WebSocketBot) reader() {
+	defer close(w.done)
 	defer close(w.rounds)
 	var currentRound *BotRound
+	var state *RoundStateMessage
 
 	for {
-		var msg Message
-		if err := w.c.ReadJSON(&msg); err != nil {
-			if err != io.ErrUnexpectedEOF {
-				w.mu.Lock()
-				if currentRound != nil {
-					close(currentRound.winner)
-					if !currentRound.died {
-						close(currentRound.turns)
-					}
-				}
+		msg, err := w.conn().readMessage()
+		if err != nil {
+			// A policy violation means that the server refused or kicked
+			// the bot, e.g. because its name is banned, and it should not
+			// reconnect
+			if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) && w.reconnect() {
+				continue
+			}
+			if currentRound != nil {
+				currentRound.end()
+			}
+			w.mu.Lock()
+			if ctxErr := w.ctx.Err(); ctxErr != nil {
+				w.err = ctxErr
+			} else if err != io.ErrUnexpectedEOF && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 				w.err = err
-				w.mu.Unlock()
 			}
+			w.mu.Unlock()
 			break
 		}
+		w.reconnectWait, w.reconnectStart = 0, time.Time{}
+
+		switch {
+		case msg.RoundStateMessage != nil:
+			state = msg.RoundStateMessage
+		case msg.RoundDelta != nil:
+			if state != nil {
+				state = state.ApplyDelta(msg.RoundDelta)
+			}
+			if state == nil {
+				// The delta does not apply to a known state; skip the
+				// turn until the next keyframe
+				continue
+			}
+			msg = &Message{
+				RoundStateMessage: state,
+			}
+		}
 
 		switch {
+		case msg.SessionMessage != nil:
+			w.mu.Lock()
+			w.session = msg.SessionMessage.Token
+			w.mu.Unlock()
+		case msg.MoveAck != nil:
+			w.mu.Lock()
+			select {
+			case w.acks <- msg.MoveAck:
+			default:
+			}
+			w.mu.Unlock()
+		case msg.ServerShutdown != nil:
+			// The server is going away, and will not resume the session
+			w.mu.Lock()
+			w.session = ""
+			w.mu.Unlock()
+			if currentRound != nil {
+				currentRound.end()
+				currentRound = nil
+			}
 		case msg.WaitingMessage != nil:
 		case msg.RoundPreparation != nil:
 		case msg.RoundStateMessage != nil:
@@ -79,10 +197,19 @@ func (w *WebSocketBot) reader() {
 				currentRound = &BotRound{
 					w: w,
 
-					turns:  make(chan *BotTurn),
+					turns:  make(chan *BotTurn, 1),
 					winner: make(chan string, 1),
 				}
-				w.rounds <- currentRound
+				select {
+				case w.rounds <- currentRound:
+				default:
+					// Replace the round that has not been received
+					select {
+					case <-w.rounds:
+					default:
+					}
+					w.rounds <- currentRound
+				}
 			}
 			if currentRound.died {
 				break
@@ -91,11 +218,11 @@ func (w *WebSocketBot) reader() {
 			for _, player := range msg.RoundStateMessage.Players {
 				if player.Name == w.name {
 					if len(player.Pieces) > 0 {
-						currentRound.turns <- &BotTurn{
+						currentRound.sendTurn(&BotTurn{
 							RoundStateMessage: msg.RoundStateMessage,
 
 							r: currentRound,
-						}
+						})
 					} else {
 						currentRound.died = true
 						close(currentRound.turns)
@@ -104,21 +231,95 @@ func (w *WebSocketBot) reader() {
 				}
 			}
 		case msg.RoundOverMessage != nil:
+			if currentRound == nil {
+				break
+			}
 			if msg.RoundOverMessage.Winner != nil {
 				currentRound.winner <- *msg.RoundOverMessage.Winner
 			}
-			close(currentRound.winner)
-			if !currentRound.died {
-				close(currentRound.turns)
-			}
+			currentRound.end()
 			currentRound = nil
 		default:
 		}
 	}
 }
 
+// conn returns the bot's current connection.
+func (w *WebSocketBot) conn() *wsConn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.c
+}
+
+// reconnect re-establishes the connection to the server using the bot's
+// session token, retrying with backoff. false is returned if the bot has no
+// session, the bot was closed, or the server could not be reached before
+// reconnectTimeout.
+//
+// The backoff keeps growing across calls until a message is received on the
+// new connection, so that a server that accepts the connection and then
+// rejects the bot is not retried in a tight loop.
+func (w *WebSocketBot) reconnect() bool {
+	w.mu.Lock()
+	session, closed := w.session, w.closed
+	w.mu.Unlock()
+	if session == "" || closed {
+		return false
+	}
+
+	headers := make(http.Header)
+	for key, values := range w.headers {
+		headers[key] = values
+	}
+	headers.Set("X-Snake-Session", session)
+
+	if w.reconnectStart.IsZero() {
+		w.reconnectStart = time.Now()
+	}
+	deadline := w.reconnectStart.Add(reconnectTimeout)
+	for time.Now().Before(deadline) {
+		wait := w.reconnectWait
+		if wait < reconnectMinWait {
+			wait = reconnectMinWait
+		}
+		select {
+		case <-time.After(wait):
+		case <-w.ctx.Done():
+			return false
+		}
+		if w.reconnectWait = wait * 2; w.reconnectWait > reconnectMaxWait {
+			w.reconnectWait = reconnectMaxWait
+		}
+		w.mu.Lock()
+		closed := w.closed
+		w.mu.Unlock()
+		if closed {
+			return false
+		}
+
+		conn, _, err := dial(w.ctx, w.addr, headers)
+		if err != nil {
+			continue
+		}
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.closed {
+			conn.Close()
+			return false
+		}
+		w.c.Close()
+		w.c = newWSConn(conn)
+		return true
+	}
+	return false
+}
+
 // Close closes the connection to the server.
 func (w *WebSocketBot) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
 	return w.c.Close()
 }
 
@@ -130,13 +331,28 @@ func (w *WebSocketBot) Err() error {
 }
 
 // Rounds returns a channel of BotRounds. A BotRound is sent on the channel when a
-// new rounds begins.
+// new rounds begins. A round that has not been received when the next round
+// begins is dropped.
 //
 // The returned channel is closed when the connection to the server is closed.
 func (w *WebSocketBot) Rounds() <-chan *BotRound {
 	return w.rounds
 }
 
+// Acks returns a channel on which the server's acknowledgement of each move
+// is sent. Acknowledgements are only requested once Acks has been called.
+//
+// Acknowledgements are dropped if the channel is not read from quickly enough.
+func (w *WebSocketBot) Acks() <-chan *MoveAckMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.acks == nil {
+		w.acks = make(chan *MoveAckMessage, 16)
+	}
+	return w.acks
+}
+
 // BotRound represents a game round that the bot is participating in.
 type BotRound struct {
 	w *WebSocketBot
@@ -147,7 +363,9 @@ type BotRound struct {
 }
 
 // Turns returns a channel of *BotTurns. A BotTurn is sent on the channel when the
-// next tick of the game is received from the server.
+// next tick of the game is received from the server. Only the latest turn is
+// kept: a turn that has not been received when the next tick arrives is
+// dropped.
 //
 // The returned channel is closed when the round is over, your snake dies, or the
 // connection to the server is closed.
@@ -155,6 +373,28 @@ func (b *BotRound) Turns() <-chan *BotTurn {
 	return b.turns
 }
 
+// sendTurn sends the turn on the turns channel without blocking, replacing
+// the previous turn if it has not been received.
+func (b *BotRound) sendTurn(t *BotTurn) {
+	select {
+	case b.turns <- t:
+	default:
+		select {
+		case <-b.turns:
+		default:
+		}
+		b.turns <- t
+	}
+}
+
+// end closes the round's channels.
+func (b *BotRound) end() {
+	close(b.winner)
+	if !b.died {
+		close(b.turns)
+	}
+}
+
 // Winner returns a channel on which the round winner will be sent. The channel is closed
 // without a name sent if no player won the round (i.e. the remaining players died at
 // the same time).
@@ -172,15 +412,25 @@ type BotTurn struct {
 
 // Move tells the server to move your bot in the given direction at the end of
 // the turn. Calling this function multiple times per turn will have no effect.
+//
+// The move is tagged with the turn's tick. The server rejects the move if the
+// next turn has already started.
 func (t *BotTurn) Move(direction Direction) error {
 	if !atomic.CompareAndSwapInt32(&t.moved, 0, 1) {
 		return errors.New("already moved")
 	}
 
+	t.r.w.mu.Lock()
+	ack := t.r.w.acks != nil
+	t.r.w.mu.Unlock()
+
+	tick := t.Tick
 	msg := ClientMessage{
 		DirectionClientMessage: &DirectionClientMessage{
 			Direction: direction,
+			Tick:      &tick,
+			Ack:       ack,
 		},
 	}
-	return t.r.w.c.WriteJSON(&msg)
+	return t.r.w.conn().writeJSON(&msg)
 }
@@ -0,0 +1,326 @@
+package snakes
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Built-in strategies. They are intended as sparring partners for bots that
+// are being developed, and can be added to a Server with NewStrategyClient.
+var builtinStrategies = map[string]func(seed int64) Strategy{
+	"random":    NewRandomStrategy,
+	"greedy":    func(int64) Strategy { return NewGreedyStrategy() },
+	"floodfill": func(int64) Strategy { return NewFloodFillStrategy() },
+	"lookahead": func(int64) Strategy { return NewLookaheadStrategy(2) },
+}
+
+// BuiltinStrategy returns the constructor of the built-in strategy with the
+// given name (random, greedy, floodfill or lookahead). The seed passed to the
+// constructor is used by strategies that make random decisions. false is
+// returned if there is no such strategy.
+func BuiltinStrategy(name string) (func(seed int64) Strategy, bool) {
+	newStrategy, ok := builtinStrategies[name]
+	return newStrategy, ok
+}
+
+// BuiltinStrategyNames returns the sorted names of the built-in strategies.
+func BuiltinStrategyNames() []string {
+	names := make([]string, 0, len(builtinStrategies))
+	for name := range builtinStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// allDirections contains every valid direction.
+var allDirections = [...]Direction{DirectionNorth, DirectionEast, DirectionSouth, DirectionWest}
+
+// arenaGrid is the set of blocked locations in the arena.
+type arenaGrid struct {
+	width, height int
+	blocked       []bool
+}
+
+// newArenaGrid creates an arenaGrid from the given state. Walls and every
+// snake piece are blocked.
+func newArenaGrid(state *RoundStateMessage) *arenaGrid {
+	g := &arenaGrid{
+		width:   state.Width,
+		height:  state.Height,
+		blocked: make([]bool, state.Width*state.Height),
+	}
+	for _, wall := range state.Walls {
+		g.block(wall)
+	}
+	for _, player := range state.Players {
+		for _, piece := range player.Pieces {
+			g.block(piece)
+		}
+	}
+	return g
+}
+
+func (g *arenaGrid) block(l Location) {
+	if l.IsInsideBounds(g.width, g.height) {
+		g.blocked[l.Y*g.width+l.X] = true
+	}
+}
+
+// isFree returns if the location is inside of the arena and is not blocked.
+func (g *arenaGrid) isFree(l Location) bool {
+	return l.IsInsideBounds(g.width, g.height) && !g.blocked[l.Y*g.width+l.X]
+}
+
+// reachable returns the number of free locations that can be reached from l,
+// including l. Counting stops once limit locations have been reached.
+func (g *arenaGrid) reachable(l Location, limit int) int {
+	if !g.isFree(l) {
+		return 0
+	}
+
+	seen := make([]bool, len(g.blocked))
+	seen[l.Y*g.width+l.X] = true
+	queue := []Location{l}
+	count := 0
+
+	for len(queue) > 0 && count < limit {
+		current := queue[0]
+		queue = queue[1:]
+		count++
+
+		for _, d := range allDirections {
+			next := NextLocation(current, d)
+			if g.isFree(next) && !seen[next.Y*g.width+next.X] {
+				seen[next.Y*g.width+next.X] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return count
+}
+
+// distance returns the Manhattan distance between two locations.
+func distance(a, b Location) int {
+	dx, dy := a.X-b.X, a.Y-b.Y
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	return dx + dy
+}
+
+// safeDirections returns the directions that the snake head at l can move
+// in without immediately colliding with a wall, the arena edge or a snake.
+func safeDirections(g *arenaGrid, l Location) []Direction {
+	var directions []Direction
+	for _, d := range allDirections {
+		if g.isFree(NextLocation(l, d)) {
+			directions = append(directions, d)
+		}
+	}
+	return directions
+}
+
+// NewRandomStrategy returns a strategy that moves in a random direction that
+// does not immediately collide with anything. Strategies created with the same
+// seed make the same decisions.
+func NewRandomStrategy(seed int64) Strategy {
+	rng := rand.New(rand.NewSource(seed))
+
+	return StrategyFunc(func(state *RoundStateMessage, self string) Direction {
+		head := state.Player(self).Pieces[0]
+		directions := safeDirections(newArenaGrid(state), head)
+		if len(directions) == 0 {
+			return state.Player(self).Direction
+		}
+		return directions[rng.Intn(len(directions))]
+	})
+}
+
+// NewGreedyStrategy returns a strategy that moves towards the apple, while
+// avoiding immediate collisions.
+func NewGreedyStrategy() Strategy {
+	return StrategyFunc(func(state *RoundStateMessage, self string) Direction {
+		pieces := state.Player(self).Pieces
+		best := state.Player(self).Direction
+		bestDistance := math.MaxInt32
+		for _, d := range safeDirections(newArenaGrid(state), pieces[0]) {
+			if dist := distance(NextLocation(pieces[0], d), state.Apple.Location); dist < bestDistance {
+				best = d
+				bestDistance = dist
+			}
+		}
+		return best
+	})
+}
+
+// NewFloodFillStrategy returns a strategy that prefers to move into the area
+// with the most free space, moving towards the apple when areas are of equal
+// size.
+func NewFloodFillStrategy() Strategy {
+	return StrategyFunc(func(state *RoundStateMessage, self string) Direction {
+		pieces := state.Player(self).Pieces
+		g := newArenaGrid(state)
+
+		best := state.Player(self).Direction
+		bestArea, bestDistance := -1, math.MaxInt32
+		for _, d := range safeDirections(g, pieces[0]) {
+			next := NextLocation(pieces[0], d)
+			area := g.reachable(next, len(g.blocked))
+			dist := distance(next, state.Apple.Location)
+			if area > bestArea || (area == bestArea && dist < bestDistance) {
+				best = d
+				bestArea, bestDistance = area, dist
+			}
+		}
+		return best
+	})
+}
+
+// NewLookaheadStrategy returns a strategy that searches depth turns ahead
+// using minimax against the nearest opponent. Other opponents are assumed to
+// keep moving in their current direction.
+//
+// Positions are scored by the free space available to the bot, its length
+// and its distance from the apple.
+func NewLookaheadStrategy(depth int) Strategy {
+	if depth < 1 {
+		depth = 1
+	}
+
+	return StrategyFunc(func(state *RoundStateMessage, self string) Direction {
+		s, selfNo := stateFromMessage(state, self)
+		head := s.Snakes[selfNo].Pieces[0]
+
+		opponentNo := -1
+		opponentDistance := math.MaxInt32
+		for i, snake := range s.Snakes {
+			if i == selfNo || !snake.Alive {
+				continue
+			}
+			if dist := distance(head, snake.Pieces[0]); dist < opponentDistance {
+				opponentNo, opponentDistance = i, dist
+			}
+		}
+
+		directions := make([]Direction, len(s.Snakes))
+		for i, snake := range s.Snakes {
+			if snake.Alive {
+				directions[i] = snake.Direction
+			}
+		}
+
+		best := directions[selfNo]
+		bestScore := math.Inf(-1)
+		for _, d := range allDirections {
+			directions[selfNo] = d
+			if score := lookahead(s, directions, selfNo, opponentNo, s.Apple.Location, depth); score > bestScore {
+				best, bestScore = d, score
+			}
+		}
+		return best
+	})
+}
+
+// lookahead returns the minimax score of the bot moving in its direction in
+// directions, assuming the opponent picks the move that is worst for the bot.
+// apple is the location of the apple at the start of the search.
+func lookahead(s *State, directions []Direction, selfNo, opponentNo int, apple Location, depth int) float64 {
+	opponentMoves := allDirections[:]
+	if opponentNo < 0 {
+		opponentMoves = allDirections[:1]
+	}
+
+	worst := math.Inf(1)
+	for _, od := range opponentMoves {
+		moves := make([]Direction, len(directions))
+		copy(moves, directions)
+		if opponentNo >= 0 {
+			moves[opponentNo] = od
+		}
+
+		next := s.Next(moves)
+		var score float64
+		switch {
+		case !next.Snakes[selfNo].Alive:
+			score = math.Inf(-1)
+		case depth <= 1:
+			score = scoreState(next, selfNo, apple)
+		default:
+			for i, snake := range next.Snakes {
+				if snake.Alive {
+					moves[i] = snake.Direction
+				}
+			}
+			score = math.Inf(-1)
+			for _, d := range allDirections {
+				moves[selfNo] = d
+				if v := lookahead(next, moves, selfNo, opponentNo, apple, depth-1); v > score {
+					score = v
+				}
+			}
+		}
+
+		if score < worst {
+			worst = score
+		}
+	}
+	return worst
+}
+
+// scoreState scores the state from the point of view of the given snake.
+//
+// The distance is measured to the apple's location at the start of the
+// search, as the location of a newly placed apple is not known to the bot.
+func scoreState(s *State, selfNo int, apple Location) float64 {
+	self := s.Snakes[selfNo]
+	msg := roundStateMessageFromState(make([]string, len(s.Snakes)), s)
+	g := newArenaGrid(msg)
+
+	// Free the head so that the area reachable from it can be counted. Any
+	// area larger than a few times the snake's length is considered safe.
+	head := self.Pieces[0]
+	g.blocked[head.Y*g.width+head.X] = false
+	area := g.reachable(head, self.Length*4)
+
+	return float64(area) + float64(self.Length)*100 - float64(distance(head, apple))
+}
+
+// stateFromMessage re-creates a game State from a RoundStateMessage, and
+// returns the snake number of the given player.
+//
+// The state uses ClassicRuleset (WallsRuleset if the arena has walls), and
+// the location of the apple after it is eaten does not match the server.
+func stateFromMessage(msg *RoundStateMessage, self string) (*State, int) {
+	s := &State{
+		Width:  msg.Width,
+		Height: msg.Height,
+		Snakes: make([]*Snake, len(msg.Players)),
+		Apple:  msg.Apple,
+		Walls:  msg.Walls,
+		Items:  msg.Items,
+	}
+	if len(msg.Walls) > 0 {
+		s.Ruleset = WallsRuleset{Walls: msg.Walls}
+	}
+
+	selfNo := -1
+	for i, player := range msg.Players {
+		if player.Name == self {
+			selfNo = i
+		}
+		s.Snakes[i] = &Snake{
+			Alive:     len(player.Pieces) > 0,
+			Length:    len(player.Pieces),
+			Pieces:    make([]Location, len(player.Pieces)),
+			Direction: player.Direction,
+			Phase:     player.Phase,
+		}
+		copy(s.Snakes[i].Pieces, player.Pieces)
+	}
+	return s, selfNo
+}
@@ -14,6 +14,23 @@ type Client interface {
 	ViewerClient
 }
 
+// MoveWaiter is implemented by clients that can report when they have
+// submitted their move for a tick. It is used by the server's lockstep tick
+// mode (see ServerConfig.Lockstep).
+type MoveWaiter interface {
+	// Moved returns a channel that is closed once the client has submitted
+	// its move for the given tick.
+	Moved(tick int) <-chan struct{}
+}
+
+// DirectionResetter is implemented by clients that keep their direction
+// between rounds. At the start of each round, the server resets the direction
+// to the one that the client's snake spawned facing, so that the snake keeps
+// moving that way until the client chooses a direction.
+type DirectionResetter interface {
+	ResetDirection(Direction)
+}
+
 // ViewerClient is a client that is broadcast every message that the server
 // broadcasts to regular clients.
 // A ViewerClient does not control a snake in the arena.
@@ -30,7 +47,41 @@ type Message struct {
 	WaitingMessage    *WaitingMessage          `json:"waiting,omitempty"`
 	RoundPreparation  *RoundPreparationMessage `json:"round_preparation,omitempty"`
 	RoundStateMessage *RoundStateMessage       `json:"round_state,omitempty"`
+	RoundDelta        *RoundDeltaMessage       `json:"round_delta,omitempty"`
 	RoundOverMessage  *RoundOverMessage        `json:"round_over,omitempty"`
+	ViewerStatus      *ViewerStatusMessage     `json:"viewer_status,omitempty"`
+	SessionMessage    *SessionMessage          `json:"session,omitempty"`
+	MoveAck           *MoveAckMessage          `json:"move_ack,omitempty"`
+	ServerShutdown    *ServerShutdownMessage   `json:"server_shutdown,omitempty"`
+}
+
+// ServerShutdownMessage is broadcast when the server is shutting down. It is
+// the last message that the server sends before closing the connection.
+type ServerShutdownMessage struct {
+}
+
+// SessionMessage is sent to a client when it is added to the server. The
+// token can be presented when reconnecting to resume control of the client's
+// snake (see Server.ResumeClient).
+type SessionMessage struct {
+	Token string `json:"token"`
+}
+
+// MoveAckMessage is sent to a client in response to a DirectionClientMessage
+// that requested an acknowledgement.
+type MoveAckMessage struct {
+	// Tick that the move was made for.
+	Tick int `json:"tick"`
+	// If the move will be applied. Moves are rejected if they are for a tick
+	// other than the latest tick sent to the client.
+	Accepted bool `json:"accepted"`
+	// If the move was rejected because it was for a previous tick.
+	Stale bool `json:"stale"`
+	// Number of ticks between the move's tick and the latest tick.
+	TicksBehind int `json:"ticks_behind"`
+	// Time, in milliseconds, between the server sending the latest tick and
+	// receiving the move.
+	LatencyMS int64 `json:"latency_ms"`
 }
 
 // WaitingMessage is broadcast when the server is waiting for the minimum
@@ -51,6 +102,10 @@ type RoundPreparationMessage struct {
 // RoundStateMessage is broadcast while the round is active. It contains a snapshot
 // of the state of the game arena.
 type RoundStateMessage struct {
+	// Tick number of the state. The initial state of a round is tick 0, and
+	// the tick increases by one with each state of the round.
+	Tick int `json:"tick"`
+
 	Width  int `json:"width"`
 	Height int `json:"height"`
 
@@ -58,14 +113,38 @@ type RoundStateMessage struct {
 
 	Apple Apple `json:"apple"`
 
+	// Locations of walls inside of the arena.
+	Walls []Location `json:"walls,omitempty"`
+
+	// Items in the arena, in addition to the apple.
+	Items []Item `json:"items,omitempty"`
+
 	// Number of seconds remaining in the round. nil if there is no time limit.
 	SecondsRemaining *int `json:"seconds_remaining,omitempty"`
 }
 
+// Player returns the player with the given name, or nil if the player is not
+// in the round.
+func (m *RoundStateMessage) Player(name string) *RoundStateMessagePlayer {
+	for _, player := range m.Players {
+		if player.Name == name {
+			return player
+		}
+	}
+	return nil
+}
+
 // RoundStateMessagePlayer is a player in the round.
 type RoundStateMessagePlayer struct {
 	Name   string     `json:"name"`
 	Pieces []Location `json:"pieces"`
+	// Direction that the snake is facing. In the first state of a round, it
+	// is the direction in which the snake moves if the player does not
+	// choose one.
+	Direction Direction `json:"direction"`
+	// Number of ticks that the snake can move through snake bodies for
+	// (see ItemPhase).
+	Phase int `json:"phase,omitempty"`
 }
 
 // IsAt returns if any of the player's pieces is at the given location.
@@ -78,21 +157,27 @@ func (p *RoundStateMessagePlayer) IsAt(l Location) bool {
 	return false
 }
 
-// roundStateMessageFromState creates a RoundStateMessage from the given clients
-// and game state.
-func roundStateMessageFromState(clients []Client, s *State) *RoundStateMessage {
+// roundStateMessageFromState creates a RoundStateMessage from the given player
+// names and game state.
+func roundStateMessageFromState(names []string, s *State) *RoundStateMessage {
 	m := &RoundStateMessage{
+		Tick: s.Tick,
+
 		Width:  s.Width,
 		Height: s.Height,
 
-		Players: make([]*RoundStateMessagePlayer, len(clients)),
+		Players: make([]*RoundStateMessagePlayer, len(names)),
 
 		Apple: s.Apple,
+		Walls: s.Walls,
+		Items: s.Items,
 	}
 
-	for i, client := range clients {
+	for i, name := range names {
 		p := &RoundStateMessagePlayer{
-			Name: client.ID(),
+			Name:      name,
+			Direction: s.Snakes[i].Direction,
+			Phase:     s.Snakes[i].Phase,
 		}
 		if snake := s.Snakes[i]; snake.Alive {
 			p.Pieces = make([]Location, len(snake.Pieces))
@@ -104,9 +189,29 @@ func roundStateMessageFromState(clients []Client, s *State) *RoundStateMessage {
 	return m
 }
 
+// ViewerStatusMessage is sent to a viewer after it sends a
+// ViewerControlMessage. It describes what the viewer is currently watching.
+type ViewerStatusMessage struct {
+	// If the viewer is following the live round.
+	Live bool `json:"live"`
+	// If playback of a historical round is paused.
+	Paused bool `json:"paused"`
+	// ID of the round being watched, and the tick within the round.
+	Round int `json:"round"`
+	Tick  int `json:"tick"`
+	// Number of ticks in the round being watched.
+	Ticks int `json:"ticks"`
+	// IDs of the rounds that are available in the history, oldest first.
+	Rounds []int `json:"rounds"`
+}
+
 // RoundOverMessage is broadcast when the round is over.
 // If there was no winner (e.g. all remaining snakes died at the same time), Winner
 // will be nil.
 type RoundOverMessage struct {
 	Winner *string `json:"winner"`
+	// Aborted is true if the round was ended early without a result, e.g.
+	// restarted by an admin (see Server.RestartRound) or stopped by a
+	// server shutdown. Aborted rounds do not count towards ratings.
+	Aborted bool `json:"aborted,omitempty"`
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bontibon/go-workshop/snakes"
+)
+
+func main() {
+	games := flag.Int("games", 1000, "number of games to play")
+	parallelism := flag.Int("parallelism", 0, "number of games to play at the same time (defaults to the number of CPUs)")
+	maxTicks := flag.Int("max-ticks", 150, "maximum number of ticks in a game (0 for no limit)")
+	width := flag.Int("width", 0, "arena width (0 sizes the arena from the number of players)")
+	height := flag.Int("height", 0, "arena height (0 sizes the arena from the number of players)")
+	ruleset := flag.String("ruleset", "classic", "game rules (classic, wraparound, walls)")
+	itemValues := flag.String("items", "", "comma separated list of items kept in the arena in addition to the apple, as type:count[:lifetime] (types: apple, golden_apple, shrink, phase)")
+	mapFile := flag.String("map", "", "map file to play on (overrides the arena size and spawn strategy)")
+	spawn := flag.String("spawn", "line", "snake spawn strategy (line, circle, random, corners)")
+	placement := flag.String("placement", "uniform", "apple and item placement policy (uniform, distant, fair)")
+	seed := flag.Int64("seed", 0, "seed of the first game (0 uses the current time)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <strategy> <strategy> [strategy...]\n\nstrategies:\n", os.Args[0])
+		names := snakes.BuiltinStrategyNames()
+		for name := range strategies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", name)
+		}
+		fmt.Fprintln(flag.CommandLine.Output(), "\nflags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rules, ok := snakes.RulesetByName(*ruleset)
+	if !ok {
+		log.Fatalf("unknown ruleset %q", *ruleset)
+	}
+	spawns, ok := snakes.SpawnByName(*spawn)
+	if !ok {
+		log.Fatalf("unknown spawn strategy %q", *spawn)
+	}
+	placements, ok := snakes.PlacementByName(*placement)
+	if !ok {
+		log.Fatalf("unknown placement policy %q", *placement)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	players := make([]snakes.ArenaPlayer, flag.NArg())
+	for i, name := range flag.Args() {
+		newStrategy, ok := strategies[name]
+		if !ok {
+			newStrategy, ok = snakes.BuiltinStrategy(name)
+		}
+		if !ok {
+			log.Fatalf("unknown strategy %q", name)
+		}
+		players[i] = snakes.ArenaPlayer{
+			Name:        strconv.Itoa(i+1) + "-" + name,
+			NewStrategy: newStrategy,
+		}
+	}
+
+	cfg := snakes.ArenaConfig{
+		Width:       *width,
+		Height:      *height,
+		Ruleset:     rules,
+		Spawn:       spawns,
+		Placement:   placements,
+		MaxTicks:    *maxTicks,
+		Games:       *games,
+		Parallelism: *parallelism,
+		Seed:        *seed,
+	}
+	if *itemValues != "" {
+		for _, value := range strings.Split(*itemValues, ",") {
+			item, err := snakes.ParseItemConfig(value)
+			if err != nil {
+				log.Fatal(err)
+			}
+			cfg.Items = append(cfg.Items, item)
+		}
+	}
+	if *mapFile != "" {
+		var err error
+		if cfg.Map, err = snakes.LoadMap(*mapFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	result, err := snakes.RunArena(cfg, players)
+	if err != nil {
+		log.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	fmt.Printf("%d games in %s (seed %d)\n\n", result.Games, elapsed.Round(time.Millisecond), *seed)
+	fmt.Printf("%-20s %8s %8s %8s %8s\n", "player", "wins", "draws", "losses", "win %")
+	for _, player := range result.Players {
+		var winRate float64
+		if result.Games > 0 {
+			winRate = float64(player.Wins) / float64(result.Games) * 100
+		}
+		fmt.Printf("%-20s %8d %8d %8d %7.1f%%\n", player.Name, player.Wins, player.Draws, player.Losses, winRate)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/bontibon/go-workshop/snakes"
+)
+
+// strategies are custom strategies that can be played in the arena, in
+// addition to the built-in strategies (see snakes.BuiltinStrategy).
+//
+// Add your own bot's strategy here to test it against the others. Use the
+// seed for any random decisions, so that games can be replayed with --seed.
+var strategies = map[string]func(seed int64) snakes.Strategy{
+	"chaser": newChaserStrategy,
+}
+
+// newChaserStrategy returns a strategy that moves directly towards the apple.
+// It is the same logic as the example in cmd/snakes-bot.
+func newChaserStrategy(seed int64) snakes.Strategy {
+	return snakes.StrategyFunc(func(state *snakes.RoundStateMessage, self string) snakes.Direction {
+		loc := state.Player(self).Pieces[0]
+
+		switch {
+		case loc.X < state.Apple.X:
+			return snakes.DirectionEast
+		case loc.X > state.Apple.X:
+			return snakes.DirectionWest
+		case loc.Y < state.Apple.Y:
+			return snakes.DirectionSouth
+		}
+		return snakes.DirectionNorth
+	})
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"log"
+	"os"
+	"os/signal"
 
 	"github.com/bontibon/go-workshop/snakes"
 )
@@ -18,7 +21,11 @@ func main() {
 	// TODO: change me!
 	name := "Bot-" + RandomName()
 
-	bot, err := snakes.NewWebSocketBot(addr, name)
+	// Disconnect from the server when the program is interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	bot, err := snakes.DialWebSocketBot(ctx, addr, name)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -28,36 +35,9 @@ func main() {
 	for round := range bot.Rounds() {
 		log.Println("New round started")
 
+		strategy := &Strategy{}
 		for turn := range round.Turns() {
-			//
-			//
-			//
-			// TODO: create your bot logic here!
-			//
-			//
-			// Control your bot with turn.Move. Example:
-			// turn.Move(snakes.DirectionNorth)
-
-			// Find your bot's location
-			var loc snakes.Location
-			for _, player := range turn.Players {
-				if player.Name == name {
-					loc = player.Pieces[0]
-					break
-				}
-			}
-
-			// Target the apple
-			// TODO: adapt so you do not move your bot into a location where another player already is
-			if loc.X < turn.Apple.X {
-				turn.Move(snakes.DirectionEast)
-			} else if loc.X > turn.Apple.X {
-				turn.Move(snakes.DirectionWest)
-			} else if loc.Y < turn.Apple.Y {
-				turn.Move(snakes.DirectionSouth)
-			} else {
-				turn.Move(snakes.DirectionNorth)
-			}
+			turn.Move(strategy.Decide(turn.RoundStateMessage, name))
 		}
 
 		if winner, someoneWon := <-round.Winner(); someoneWon {
@@ -67,11 +47,44 @@ func main() {
 		}
 	}
 
-	if err := bot.Err(); err != nil {
+	if err := bot.Err(); err != nil && err != context.Canceled {
 		log.Fatal(err)
 	}
 }
 
+// Strategy is your bot's logic. It implements snakes.Strategy, so it can
+// also be tested offline with snakes.RunArena.
+//
+// A new Strategy is created at the start of every round.
+type Strategy struct {
+}
+
+var _ snakes.Strategy = (*Strategy)(nil)
+
+// Decide returns the direction in which your bot should move this turn.
+func (s *Strategy) Decide(state *snakes.RoundStateMessage, self string) snakes.Direction {
+	//
+	//
+	//
+	// TODO: create your bot logic here!
+	//
+	//
+
+	// Find your bot's location
+	loc := state.Player(self).Pieces[0]
+
+	// Target the apple
+	// TODO: adapt so you do not move your bot into a location where another player already is
+	if loc.X < state.Apple.X {
+		return snakes.DirectionEast
+	} else if loc.X > state.Apple.X {
+		return snakes.DirectionWest
+	} else if loc.Y < state.Apple.Y {
+		return snakes.DirectionSouth
+	}
+	return snakes.DirectionNorth
+}
+
 func RandomName() string {
 	var b [3]byte
 	if _, err := rand.Read(b[:]); err != nil {
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bontibon/go-workshop/snakes"
+)
+
+// requireAdmin wraps the handler so that it is only served to requests that
+// present the admin token in the Authorization header (Authorization: Bearer <token>).
+func requireAdmin(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// tokensHandler manages bot API tokens:
+//
+//	GET /admin/tokens               lists the registered bot names
+//	POST /admin/tokens?name=<bot>   mints a new token for the bot
+//	DELETE /admin/tokens?name=<bot> revokes the bot's token, and kicks the
+//	                                bot from every room
+func tokensHandler(tokens *snakes.TokenStore, rooms *snakes.Rooms) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, tokens.Names())
+		case http.MethodPost:
+			token, err := tokens.Mint(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, map[string]string{
+				"name":  name,
+				"token": token,
+			})
+		case http.MethodDelete:
+			ok, err := tokens.Revoke(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			for _, room := range rooms.Names() {
+				rooms.Get(room).Kick(name)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// roomHandler serves requests for the room selected with the room query
+// parameter (or the X-Snake-Room header), responding with 404 if the room
+// does not exist.
+func roomHandler(rooms *snakes.Rooms, h func(w http.ResponseWriter, r *http.Request, server *snakes.Server)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server := rooms.Get(snakes.RoomName(r))
+		if server == nil {
+			http.Error(w, "unknown room", http.StatusNotFound)
+			return
+		}
+		h(w, r, server)
+	})
+}
+
+// statusHandler lists a room's clients, viewers and bans, and the state of its
+// game loop:
+//
+//	GET /admin/status
+func statusHandler(w http.ResponseWriter, r *http.Request, server *snakes.Server) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, server.Status())
+}
+
+// clientsHandler manages a room's clients:
+//
+//	GET /admin/clients              lists the connected clients
+//	DELETE /admin/clients?name=<bot> kicks the bot
+func clientsHandler(w http.ResponseWriter, r *http.Request, server *snakes.Server) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, server.Status().Clients)
+	case http.MethodDelete:
+		if !server.Kick(r.URL.Query().Get("name")) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// viewersHandler lists a room's viewers:
+//
+//	GET /admin/viewers
+func viewersHandler(w http.ResponseWriter, r *http.Request, server *snakes.Server) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, server.Status().Viewers)
+}
+
+// bansHandler manages a room's banned bot names:
+//
+//	GET /admin/bans               lists the banned bot names
+//	POST /admin/bans?name=<bot>   bans the bot, kicking it if it is connected
+//	DELETE /admin/bans?name=<bot> lifts the ban
+func bansHandler(w http.ResponseWriter, r *http.Request, server *snakes.Server) {
+	name := r.URL.Query().Get("name")
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, server.Banned())
+	case http.MethodPost:
+		if name == "" {
+			http.Error(w, "missing name", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"name":   name,
+			"kicked": server.Ban(name),
+		})
+	case http.MethodDelete:
+		if !server.Unban(name) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// roundHandler controls a room's game loop:
+//
+//	POST /admin/round?action=end     ends the round, won by the longest snake
+//	POST /admin/round?action=restart ends the round without a winner and starts another
+//	POST /admin/round?action=pause   pauses the game loop
+//	POST /admin/round?action=resume  resumes the game loop
+//
+// end and restart respond with 409 if there is no round in progress.
+func roundHandler(w http.ResponseWriter, r *http.Request, server *snakes.Server) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ok := true
+	switch action := r.URL.Query().Get("action"); action {
+	case "end":
+		ok = server.EndRound()
+	case "restart":
+		ok = server.RestartRound()
+	case "pause":
+		server.Pause()
+	case "resume":
+		server.Resume()
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		http.Error(w, "no round in progress", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// duration is a time.Duration that is encoded in JSON as a string, such as
+// "200ms", like the duration command line flags.
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// adminConfig contains the snakes.ServerConfig fields that can be changed with
+// /admin/config. Fields that are unset in a request are left unchanged.
+type adminConfig struct {
+	MinimumClients *int      `json:"minimum_clients,omitempty"`
+	PreRoundWait   *duration `json:"pre_round_wait,omitempty"`
+	RoundDuration  *duration `json:"round_duration,omitempty"`
+	RoundTick      *duration `json:"round_tick,omitempty"`
+	Lockstep       *bool     `json:"lockstep,omitempty"`
+	PostRoundWait  *duration `json:"post_round_wait,omitempty"`
+	SessionGrace   *duration `json:"session_grace,omitempty"`
+}
+
+// adminConfigFrom returns the adminConfig fields of config.
+func adminConfigFrom(config snakes.ServerConfig) adminConfig {
+	preRoundWait := duration(config.PreRoundWait)
+	roundDuration := duration(config.RoundDuration)
+	roundTick := duration(config.RoundTick)
+	postRoundWait := duration(config.PostRoundWait)
+	sessionGrace := duration(config.SessionGrace)
+	return adminConfig{
+		MinimumClients: &config.MinimumClients,
+		PreRoundWait:   &preRoundWait,
+		RoundDuration:  &roundDuration,
+		RoundTick:      &roundTick,
+		Lockstep:       &config.Lockstep,
+		PostRoundWait:  &postRoundWait,
+		SessionGrace:   &sessionGrace,
+	}
+}
+
+// validate returns an error if any of the set fields are out of range.
+func (c adminConfig) validate() error {
+	if c.MinimumClients != nil && *c.MinimumClients < 2 {
+		return errors.New("minimum_clients must be at least 2")
+	}
+	if c.RoundTick != nil && *c.RoundTick <= 0 {
+		return errors.New("round_tick must be positive")
+	}
+	for _, d := range []*duration{c.PreRoundWait, c.RoundDuration, c.PostRoundWait, c.SessionGrace} {
+		if d != nil && *d < 0 {
+			return errors.New("durations must not be negative")
+		}
+	}
+	return nil
+}
+
+// apply sets the fields of config that are set in c.
+func (c adminConfig) apply(config *snakes.ServerConfig) {
+	if c.MinimumClients != nil {
+		config.MinimumClients = *c.MinimumClients
+	}
+	if c.PreRoundWait != nil {
+		config.PreRoundWait = time.Duration(*c.PreRoundWait)
+	}
+	if c.RoundDuration != nil {
+		config.RoundDuration = time.Duration(*c.RoundDuration)
+	}
+	if c.RoundTick != nil {
+		config.RoundTick = time.Duration(*c.RoundTick)
+	}
+	if c.Lockstep != nil {
+		config.Lockstep = *c.Lockstep
+	}
+	if c.PostRoundWait != nil {
+		config.PostRoundWait = time.Duration(*c.PostRoundWait)
+	}
+	if c.SessionGrace != nil {
+		config.SessionGrace = time.Duration(*c.SessionGrace)
+	}
+}
+
+// configHandler manages a room's configuration:
+//
+//	GET /admin/config   returns the configuration in use
+//	PATCH /admin/config changes the fields in the JSON request body, e.g.
+//	                    {"minimum_clients": 4, "round_tick": "100ms"}
+//
+// Changes take effect before the next round begins. PATCH responds with the
+// configuration that the next round will use.
+func configHandler(w http.ResponseWriter, r *http.Request, server *snakes.Server) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, adminConfigFrom(server.Config()))
+	case http.MethodPatch:
+		var update adminConfig
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := update.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		config := server.UpdateConfig(update.apply)
+		writeJSON(w, adminConfigFrom(config))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
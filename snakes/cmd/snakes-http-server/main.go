@@ -1,35 +1,335 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"html"
 	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/bontibon/go-workshop/snakes"
 	"github.com/gorilla/websocket"
 )
 
+// roomFlags is a repeatable flag that defines a game room.
+//
+// Each value is a room name, optionally followed by comma separated
+// key=value configuration overrides. For example:
+//
+//	ranked,minimum-clients=4,round-tick=100ms,ruleset=walls
+type roomFlags []string
+
+func (r *roomFlags) String() string {
+	return strings.Join(*r, " ")
+}
+
+func (r *roomFlags) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+// roomConfig is the configuration of a game room.
+type roomConfig struct {
+	name   string
+	server snakes.ServerConfig
+	// Names of the built-in strategies that are added to the room as bots.
+	bots []string
+}
+
+// parseRoom parses a room flag value. Configuration that is not overridden
+// is taken from base.
+func parseRoom(value string, base roomConfig) (roomConfig, error) {
+	parts := strings.Split(value, ",")
+	room := base
+	room.name = parts[0]
+	name, config := room.name, &room.server
+
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return room, fmt.Errorf("room %s: invalid option %q", name, part)
+		}
+		var err error
+		switch kv[0] {
+		case "minimum-clients":
+			config.MinimumClients, err = strconv.Atoi(kv[1])
+		case "pre-round-wait":
+			config.PreRoundWait, err = time.ParseDuration(kv[1])
+		case "round-duration":
+			config.RoundDuration, err = time.ParseDuration(kv[1])
+		case "round-tick":
+			config.RoundTick, err = time.ParseDuration(kv[1])
+		case "lockstep":
+			config.Lockstep, err = strconv.ParseBool(kv[1])
+		case "post-round-wait":
+			config.PostRoundWait, err = time.ParseDuration(kv[1])
+		case "session-grace":
+			config.SessionGrace, err = time.ParseDuration(kv[1])
+		case "record-dir":
+			config.RecordDir = kv[1]
+		case "seed":
+			config.Seed, err = strconv.ParseInt(kv[1], 10, 64)
+		case "bots":
+			room.bots = strings.Split(kv[1], "+")
+		case "items":
+			config.Items, err = parseItems(strings.Split(kv[1], "+"))
+		case "maps":
+			config.Maps, err = loadMaps(strings.Split(kv[1], "+"))
+		case "width":
+			config.Width, err = strconv.Atoi(kv[1])
+		case "height":
+			config.Height, err = strconv.Atoi(kv[1])
+		case "cells-per-snake":
+			config.CellsPerSnake, err = strconv.Atoi(kv[1])
+		case "spawn":
+			var ok bool
+			if config.Spawn, ok = snakes.SpawnByName(kv[1]); !ok {
+				err = fmt.Errorf("unknown spawn strategy %q", kv[1])
+			}
+		case "ruleset":
+			var ok bool
+			if config.Ruleset, ok = snakes.RulesetByName(kv[1]); !ok {
+				err = fmt.Errorf("unknown ruleset %q", kv[1])
+			}
+		case "placement":
+			var ok bool
+			if config.Placement, ok = snakes.PlacementByName(kv[1]); !ok {
+				err = fmt.Errorf("unknown placement policy %q", kv[1])
+			}
+		default:
+			err = fmt.Errorf("unknown option %q", kv[0])
+		}
+		if err != nil {
+			return room, fmt.Errorf("room %s: %s", name, err)
+		}
+	}
+
+	for _, bot := range room.bots {
+		if _, ok := snakes.BuiltinStrategy(bot); !ok {
+			return room, fmt.Errorf("room %s: unknown bot %q", name, bot)
+		}
+	}
+
+	return room, nil
+}
+
+// parseItems parses item configurations of the form type:count[:lifetime].
+func parseItems(values []string) ([]snakes.ItemConfig, error) {
+	items := make([]snakes.ItemConfig, len(values))
+	for i, value := range values {
+		var err error
+		if items[i], err = snakes.ParseItemConfig(value); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// loadMaps loads the map files at the given paths.
+func loadMaps(paths []string) ([]*snakes.Map, error) {
+	maps := make([]*snakes.Map, len(paths))
+	for i, path := range paths {
+		var err error
+		if maps[i], err = snakes.LoadMap(path); err != nil {
+			return nil, err
+		}
+	}
+	return maps, nil
+}
+
+// runClient reads from the client until its connection is lost, then
+// disconnects it from the server. The client is left alone if a new
+// connection has resumed its session in the meantime.
+func runClient(server *snakes.Server, client *snakes.WebSocketClient, generation int, addr net.Addr) {
+	err := client.Run()
+	if err == snakes.ErrConnectionReplaced {
+		log.Printf("Client session taken over by a new connection (%s, %s)", client.ID(), addr)
+		return
+	}
+	if err != nil {
+		log.Printf("Client error (%s): %s", addr, err)
+	}
+	server.DisconnectClient(client, generation)
+}
+
 func main() {
+	var roomValues roomFlags
+	flag.Var(&roomValues, "room", "game room definition: name[,option=value...] (repeatable; the first room is the default)")
 	minimumClients := flag.Int("minimum-clients", 2, "minimum number of clients needed to start a round")
 	preRoundWait := flag.Duration("pre-round-wait", time.Second*2, "pre round wait time")
 	roundDuration := flag.Duration("round-duration", time.Second*30, "maximum round time")
 	roundTick := flag.Duration("round-tick", time.Millisecond*200, "round tick duration")
+	lockstep := flag.Bool("lockstep", false, "start the next tick as soon as every bot has moved (round-tick is the maximum wait)")
 	postRoundWait := flag.Duration("post-round-wait", time.Second*2, "post round wait time")
+	abortRound := flag.Bool("abort-round-on-shutdown", false, "end the round in progress immediately on shutdown, instead of playing it to the end")
+	sessionGrace := flag.Duration("session-grace", time.Second*10, "amount of time a disconnected bot can reconnect and resume control of its snake")
+	sendQueueSize := flag.Int("send-queue-size", snakes.DefaultSendQueueSize, "maximum number of messages queued for each bot and viewer")
+	slowConsumer := flag.String("slow-consumer", "drop-oldest", "what to do when a bot or viewer's send queue is full (drop-oldest, drop-newest, disconnect)")
+	recordDir := flag.String("record-dir", "", "directory in which to write a replay file for every round")
+	seed := flag.Int64("seed", 0, "seed for round randomness (0 uses the current time)")
+	width := flag.Int("width", 0, "arena width (0 sizes the arena from the number of players)")
+	height := flag.Int("height", 0, "arena height (0 sizes the arena from the number of players)")
+	cellsPerSnake := flag.Int("cells-per-snake", snakes.DefaultCellsPerSnake, "number of arena cells per player when sizing the arena from the number of players")
+	ruleset := flag.String("ruleset", "classic", "game rules (classic, wraparound, walls)")
+	itemValues := flag.String("items", "", "comma separated list of items kept in the arena in addition to the apple, as type:count[:lifetime] (types: apple, golden_apple, shrink, phase)")
+	mapFiles := flag.String("maps", "", "comma separated list of map files played in rotation (overrides the arena size and spawn strategy)")
+	spawn := flag.String("spawn", "line", "snake spawn strategy (line, circle, random, corners)")
+	placement := flag.String("placement", "uniform", "apple and item placement policy (uniform, distant, fair)")
+	bots := flag.String("bots", "", "comma separated list of built-in bots added to every room ("+strings.Join(snakes.BuiltinStrategyNames(), ", ")+")")
+	ratingsFile := flag.String("ratings-file", "", "file in which bot ratings are stored (ratings are disabled if unset)")
+	ratedRooms := flag.String("rated-rooms", "", "comma separated list of rooms whose rounds are rated (all rooms if unset)")
+	tokensFile := flag.String("tokens-file", "", "file in which bot API tokens are stored (tokens are not checked if unset)")
+	requireTokens := flag.Bool("require-tokens", false, "reject bots that have not been registered with a token")
+	adminToken := flag.String("admin-token", "", "token required to use the /admin endpoints (disabled if unset)")
 	addr := flag.String("addr", "127.0.0.1:8080", "HTTP address to listen on")
 	flag.Parse()
 
-	serverConfig := snakes.ServerConfig{
+	rules, ok := snakes.RulesetByName(*ruleset)
+	if !ok {
+		log.Fatalf("unknown ruleset %q", *ruleset)
+	}
+	spawns, ok := snakes.SpawnByName(*spawn)
+	if !ok {
+		log.Fatalf("unknown spawn strategy %q", *spawn)
+	}
+	placements, ok := snakes.PlacementByName(*placement)
+	if !ok {
+		log.Fatalf("unknown placement policy %q", *placement)
+	}
+	slowConsumers, ok := snakes.SlowConsumerPolicyByName(*slowConsumer)
+	if !ok {
+		log.Fatalf("unknown slow consumer policy %q", *slowConsumer)
+	}
+
+	var maps []*snakes.Map
+	if *mapFiles != "" {
+		var err error
+		if maps, err = loadMaps(strings.Split(*mapFiles, ",")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var items []snakes.ItemConfig
+	if *itemValues != "" {
+		var err error
+		if items, err = parseItems(strings.Split(*itemValues, ",")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	baseRoom := roomConfig{}
+	if *bots != "" {
+		baseRoom.bots = strings.Split(*bots, ",")
+	}
+	baseRoom.server = snakes.ServerConfig{
 		MinimumClients: *minimumClients,
 		PreRoundWait:   *preRoundWait,
 		RoundDuration:  *roundDuration,
 		RoundTick:      *roundTick,
+		Lockstep:       *lockstep,
 		PostRoundWait:  *postRoundWait,
+		Ruleset:        rules,
+		Spawn:          spawns,
+		Placement:      placements,
+		CellsPerSnake:  *cellsPerSnake,
+		Width:          *width,
+		Height:         *height,
+		Maps:           maps,
+		Items:          items,
+		Seed:           *seed,
+		RecordDir:      *recordDir,
+		SessionGrace:   *sessionGrace,
+		SendQueueSize:  *sendQueueSize,
+		SlowConsumer:   slowConsumers,
+
+		AbortRoundOnShutdown: *abortRound,
 	}
 
-	server := snakes.NewServer(serverConfig)
-	go server.Run()
+	if len(roomValues) == 0 {
+		roomValues = roomFlags{"default"}
+	}
+
+	var tokens *snakes.TokenStore
+	if *tokensFile != "" {
+		var err error
+		if tokens, err = snakes.NewFileTokenStore(*tokensFile); err != nil {
+			log.Fatal(err)
+		}
+	} else if *requireTokens {
+		log.Fatal("--require-tokens requires --tokens-file")
+	}
+
+	var ratings *snakes.Ratings
+	if *ratingsFile != "" {
+		var err error
+		if ratings, err = snakes.NewFileRatings(*ratingsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	isRated := func(name string) bool {
+		if ratings == nil {
+			return false
+		}
+		if *ratedRooms == "" {
+			return true
+		}
+		for _, room := range strings.Split(*ratedRooms, ",") {
+			if room == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var running sync.WaitGroup
+	rooms := snakes.NewRooms()
+	for _, value := range roomValues {
+		room, err := parseRoom(value, baseRoom)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server := snakes.NewServer(room.server)
+		if err := rooms.Add(room.name, server); err != nil {
+			log.Fatalf("room %s: %s", room.name, err)
+		}
+		if isRated(room.name) {
+			server.AddViewer(ratings.Viewer())
+		}
+		// Each bot's seed is derived from the room's seed and its seat, so
+		// that games with a fixed --seed can be reproduced
+		botSeed := room.server.Seed
+		if botSeed == 0 {
+			botSeed = time.Now().UnixNano()
+		}
+		botRng := rand.New(rand.NewSource(botSeed))
+		for i, bot := range room.bots {
+			newStrategy, _ := snakes.BuiltinStrategy(bot)
+			name := fmt.Sprintf("%s-bot-%d", bot, i+1)
+			if err := server.AddClient(snakes.NewStrategyClient(name, botRng.Int63(), newStrategy)); err != nil {
+				log.Fatalf("room %s: %s", room.name, err)
+			}
+		}
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			server.Run(ctx)
+		}()
+	}
 
 	mux := http.NewServeMux()
 
@@ -38,6 +338,7 @@ func main() {
 	})
 
 	upgrader := websocket.Upgrader{
+		Subprotocols: snakes.Subprotocols,
 		CheckOrigin: func(r *http.Request) bool {
 			return true
 		},
@@ -51,11 +352,19 @@ func main() {
 		}
 		defer conn.Close()
 
+		server := rooms.Get(snakes.RoomName(r))
+		if server == nil {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "unknown room"))
+			return
+		}
+
 		defer log.Printf("Viewer disconnected (%s)", conn.RemoteAddr())
 
 		log.Printf("Viewer connected (%s)", conn.RemoteAddr())
 
 		client := snakes.NewWebSocketViewer(conn)
+		client.SetHistory(server)
+		client.SetDeltas(snakes.DeltaUpdates(r))
 		if err := server.AddViewer(client); err != nil {
 			log.Printf("could not add client: %s", err)
 			return
@@ -73,10 +382,43 @@ func main() {
 			return
 		}
 		defer conn.Close()
+
+		server := rooms.Get(snakes.RoomName(r))
+		if server == nil {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "unknown room"))
+			return
+		}
+
 		defer log.Printf("Client disconnected (%s)", conn.RemoteAddr())
 
 		log.Printf("Client connected (%s)", conn.RemoteAddr())
 
+		if tokens != nil {
+			err := tokens.Verify(r.Header.Get("X-Snake-Name"), snakes.BotToken(r))
+			if err == snakes.ErrUnregisteredBot && !*requireTokens {
+				err = nil
+			}
+			if err != nil {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+				log.Printf("could not authenticate client: %s", err)
+				return
+			}
+		}
+
+		if session := snakes.SessionToken(r); session != "" {
+			if c, generation, ok := server.ResumeClient(session); ok {
+				client, ok := c.(*snakes.WebSocketClient)
+				if !ok {
+					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session can not be resumed"))
+					return
+				}
+				log.Printf("Client resumed session (%s, %s)", client.ID(), conn.RemoteAddr())
+				client.Resume(conn)
+				runClient(server, client, generation, conn.RemoteAddr())
+				return
+			}
+		}
+
 		client, err := snakes.NewWebSocketClient(conn, r)
 		if err != nil {
 			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, err.Error()))
@@ -84,14 +426,36 @@ func main() {
 			return
 		}
 		if err := server.AddClient(client); err != nil {
-			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, err.Error()))
+			code := websocket.CloseNormalClosure
+			if err == snakes.ErrBannedClient {
+				code = websocket.ClosePolicyViolation
+			}
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, err.Error()))
 			log.Printf("could not add client: %s", err)
 			return
 		}
-		defer server.RemoveClient(client)
-		if err := client.Run(); err != nil {
-			log.Printf("Client error (%s): %s", conn.RemoteAddr(), err)
+		runClient(server, client, 0, conn.RemoteAddr())
+	})
+
+	if *adminToken != "" {
+		if tokens != nil {
+			mux.Handle("/admin/tokens", requireAdmin(*adminToken, tokensHandler(tokens, rooms)))
+		}
+		mux.Handle("/admin/status", requireAdmin(*adminToken, roomHandler(rooms, statusHandler)))
+		mux.Handle("/admin/clients", requireAdmin(*adminToken, roomHandler(rooms, clientsHandler)))
+		mux.Handle("/admin/viewers", requireAdmin(*adminToken, roomHandler(rooms, viewersHandler)))
+		mux.Handle("/admin/bans", requireAdmin(*adminToken, roomHandler(rooms, bansHandler)))
+		mux.Handle("/admin/round", requireAdmin(*adminToken, roomHandler(rooms, roundHandler)))
+		mux.Handle("/admin/config", requireAdmin(*adminToken, roomHandler(rooms, configHandler)))
+	}
+
+	mux.HandleFunc("/ratings", func(w http.ResponseWriter, r *http.Request) {
+		if ratings == nil {
+			http.NotFound(w, r)
+			return
 		}
+
+		writeJSON(w, ratings.List())
 	})
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -101,11 +465,42 @@ func main() {
 		}
 
 		w.Header().Set("Content-type", "text/html; charset=utf-8")
-		io.WriteString(w, `<h1>January 2018 Go Workshop <span style="font-weight: normal">🐍</span></h1><ul><li><a href="/viewer">/viewer</a></li><li><a href="/ws">/ws</a> (client endpoint)</li></ul>`)
+		io.WriteString(w, `<h1>January 2018 Go Workshop <span style="font-weight: normal">🐍</span></h1><ul>`)
+		for _, name := range rooms.Names() {
+			query := "?room=" + url.QueryEscape(name)
+			fmt.Fprintf(w, `<li>%s: <a href="/viewer%s">/viewer%s</a>, <a href="/ws%s">/ws%s</a> (client endpoint)</li>`,
+				html.EscapeString(name), query, html.EscapeString(query), query, html.EscapeString(query))
+		}
+		if ratings != nil {
+			io.WriteString(w, `<li><a href="/ratings">/ratings</a></li>`)
+		}
+		io.WriteString(w, `</ul>`)
 	})
 
+	httpServer := &http.Server{
+		Addr:    *addr,
+		Handler: mux,
+	}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		// Restore the default signal handling, so that a second signal
+		// exits immediately
+		stop()
+		log.Printf("Shutting down")
+		// Stop the game loops first, so that clients and viewers are told
+		// that the server is shutting down
+		running.Wait()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
 	log.Printf("Starting server on %s\n", *addr)
-	if err := http.ListenAndServe(*addr, mux); err != http.ErrServerClosed {
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-shutdownDone
+	log.Printf("Server stopped")
 }
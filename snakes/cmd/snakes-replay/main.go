@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bontibon/go-workshop/snakes"
+	"github.com/gorilla/websocket"
+)
+
+func main() {
+	speed := flag.Float64("speed", 1, "playback speed multiplier")
+	tick := flag.Duration("tick", 0, "playback tick duration (overrides the recorded round tick and speed)")
+	loop := flag.Bool("loop", true, "restart playback once the round is over")
+	viewerFile := flag.String("viewer", "../snakes-http-server/viewer.html", "path to viewer.html")
+	addr := flag.String("addr", "127.0.0.1:8081", "HTTP address to listen on")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatalf("usage: %s [flags] <replay file>", os.Args[0])
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	replay, err := snakes.ReadReplay(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	playbackTick := *tick
+	if playbackTick <= 0 {
+		if *speed <= 0 {
+			log.Fatal("speed must be greater than zero")
+		}
+		playbackTick = time.Duration(float64(replay.Header.RoundTick) / *speed)
+	}
+	if playbackTick <= 0 {
+		playbackTick = time.Millisecond * 200
+	}
+
+	log.Printf("Loaded replay with %d players and %d ticks", len(replay.Header.Players), len(replay.Ticks))
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/viewer", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, *viewerFile)
+	})
+
+	upgrader := websocket.Upgrader{
+		Subprotocols: snakes.Subprotocols,
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+
+	mux.HandleFunc("/viewer/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			io.WriteString(w, err.Error())
+			return
+		}
+		defer conn.Close()
+
+		defer log.Printf("Viewer disconnected (%s)", conn.RemoteAddr())
+
+		log.Printf("Viewer connected (%s)", conn.RemoteAddr())
+
+		viewer := snakes.NewWebSocketViewer(conn)
+		viewer.SetDeltas(snakes.DeltaUpdates(r))
+		go func() {
+			for {
+				if err := replay.Play(viewer, playbackTick); err != nil {
+					return
+				}
+				if !*loop {
+					return
+				}
+				time.Sleep(playbackTick * 10)
+			}
+		}()
+		if err := viewer.Run(); err != nil {
+			log.Printf("Viewer error (%s): %s", conn.RemoteAddr(), err)
+		}
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/viewer", http.StatusFound)
+	})
+
+	log.Printf("Starting replay server on %s\n", *addr)
+	if err := http.ListenAndServe(*addr, mux); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
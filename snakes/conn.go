@@ -0,0 +1,133 @@
+package snakes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket keepalive parameters.
+const (
+	// Maximum amount of time that a write to the peer can take.
+	writeWait = time.Second * 10
+	// Maximum amount of time to wait for a message or pong from the peer.
+	pongWait = time.Second * 60
+	// Interval at which the peer is pinged. Must be less than pongWait.
+	pingPeriod = pongWait * 9 / 10
+	// Maximum amount of time to spend sending a close message.
+	closeWait = time.Second
+)
+
+// wsConn is a WebSocket connection that can be written to from multiple
+// goroutines. gorilla/websocket supports a single concurrent writer, so every
+// write is serialised.
+//
+// The peer is pinged every pingPeriod, and reads fail if nothing has been
+// received from the peer for pongWait, so that dead peers are detected. The
+// connection must be read from for pongs to be processed.
+type wsConn struct {
+	ws *websocket.Conn
+
+	writeMu sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newWSConn wraps the WebSocket connection and starts pinging the peer.
+func newWSConn(ws *websocket.Conn) *wsConn {
+	c := &wsConn{
+		ws:   ws,
+		done: make(chan struct{}),
+	}
+
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go c.ping()
+
+	return c
+}
+
+// ping pings the peer every pingPeriod until the connection is closed.
+func (c *wsConn) ping() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+		// WriteControl can be called concurrently with the other write
+		// methods
+		if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+			return
+		}
+	}
+}
+
+// writeMessage writes the message, using the encoding of the connection's
+// subprotocol.
+func (c *wsConn) writeMessage(msg *Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return writeMessage(c.ws, msg)
+}
+
+// writeJSON writes v as a JSON message.
+func (c *wsConn) writeJSON(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteJSON(v)
+}
+
+// readMessage reads a JSON or binary encoded Message.
+func (c *wsConn) readMessage() (*Message, error) {
+	msg, err := readMessage(c.ws)
+	if err == nil {
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	}
+	return msg, err
+}
+
+// readJSON reads a JSON message into v.
+func (c *wsConn) readJSON(v interface{}) error {
+	err := c.ws.ReadJSON(v)
+	if err == nil {
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	}
+	return err
+}
+
+// read reads the payload of a message.
+func (c *wsConn) read() ([]byte, error) {
+	_, b, err := c.ws.ReadMessage()
+	if err == nil {
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	}
+	return b, err
+}
+
+// Close sends a close message to the peer, stops pinging it and closes the
+// connection.
+func (c *wsConn) Close() error {
+	return c.closeWithCode(websocket.CloseNormalClosure, "")
+}
+
+// closeWithCode is like Close, but the close message has the given close code
+// and text.
+func (c *wsConn) closeWithCode(code int, text string) error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(closeWait))
+	})
+	return c.ws.Close()
+}
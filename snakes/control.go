@@ -0,0 +1,308 @@
+package snakes
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+)
+
+// ErrBannedClient is returned by Server.AddClient for a client whose ID has
+// been banned.
+var ErrBannedClient = errors.New("banned client ID")
+
+// roundCommand is a request to end the round in progress early.
+type roundCommand int
+
+const (
+	// endRound ends the round as if it had run out of time.
+	endRound roundCommand = iota + 1
+	// restartRound aborts the round, and starts the next round without
+	// waiting ServerConfig.PostRoundWait.
+	restartRound
+)
+
+// ServerStatus is a snapshot of a Server's clients, viewers and game loop.
+type ServerStatus struct {
+	Clients         []ClientStatus `json:"clients"`
+	Viewers         []ViewerStatus `json:"viewers"`
+	Banned          []string       `json:"banned"`
+	RoundInProgress bool           `json:"round_in_progress"`
+	Paused          bool           `json:"paused"`
+}
+
+// ClientStatus describes a client added to a Server.
+type ClientStatus struct {
+	ID string `json:"id"`
+	// Address of the client's connection, if it has one.
+	Addr string `json:"addr,omitempty"`
+	// True while the client has lost its connection and can be resumed.
+	Disconnected bool `json:"disconnected,omitempty"`
+	// Number of messages waiting in the client's send queue.
+	Queued int `json:"queued"`
+}
+
+// ViewerStatus describes a viewer added to a Server.
+type ViewerStatus struct {
+	// Address of the viewer's connection, if it has one.
+	Addr string `json:"addr,omitempty"`
+	// Number of messages waiting in the viewer's send queue.
+	Queued int `json:"queued"`
+}
+
+// remoteAddr returns the address of the client or viewer's connection, if it
+// has a RemoteAddr method.
+func remoteAddr(c ViewerClient) string {
+	if conn, ok := c.(interface {
+		RemoteAddr() net.Addr
+	}); ok {
+		return conn.RemoteAddr().String()
+	}
+	return ""
+}
+
+// Status returns the current status of the server.
+func (s *Server) Status() ServerStatus {
+	status := ServerStatus{
+		Clients: []ClientStatus{},
+		Viewers: []ViewerStatus{},
+		Banned:  s.Banned(),
+	}
+
+	s.clientsMu.Lock()
+	clients := make([]Client, len(s.clients))
+	copy(clients, s.clients)
+	for _, c := range clients {
+		status.Clients = append(status.Clients, ClientStatus{
+			ID:           c.ID(),
+			Addr:         remoteAddr(c),
+			Disconnected: s.isDetached(c),
+		})
+	}
+	s.clientsMu.Unlock()
+
+	s.broadcastMu.Lock()
+	viewers := make([]ViewerClient, len(s.viewers))
+	copy(viewers, s.viewers)
+	s.broadcastMu.Unlock()
+	for _, v := range viewers {
+		status.Viewers = append(status.Viewers, ViewerStatus{
+			Addr: remoteAddr(v),
+		})
+	}
+
+	s.queuesMu.Lock()
+	for i, c := range clients {
+		if q, ok := s.queues[c]; ok {
+			status.Clients[i].Queued = q.len()
+		}
+	}
+	for i, v := range viewers {
+		if q, ok := s.queues[v]; ok {
+			status.Viewers[i].Queued = q.len()
+		}
+	}
+	s.queuesMu.Unlock()
+
+	s.controlMu.Lock()
+	status.RoundInProgress = s.roundActive
+	status.Paused = s.resumed != nil
+	s.controlMu.Unlock()
+
+	return status
+}
+
+// kicker is implemented by clients whose connection can be closed in a way
+// that tells the peer not to reconnect.
+type kicker interface {
+	kick() error
+}
+
+// Kick removes the client with the given ID from the server, and closes its
+// connection if it implements io.Closer. WebSocketClient connections are
+// closed with a policy violation, which WebSocketBot does not reconnect
+// after. The client's snake is not removed from the active round. false is
+// returned if there is no such client.
+func (s *Server) Kick(id string) bool {
+	s.clientsMu.Lock()
+	var kicked Client
+	for _, c := range s.clients {
+		if c.ID() == id {
+			kicked = c
+			break
+		}
+	}
+	if kicked != nil {
+		s.removeClient(kicked)
+	}
+	s.clientsMu.Unlock()
+
+	if kicked == nil {
+		return false
+	}
+	if k, ok := kicked.(kicker); ok {
+		k.kick()
+	} else if closer, ok := kicked.(io.Closer); ok {
+		closer.Close()
+	}
+	return true
+}
+
+// Ban prevents clients with the given ID from being added to the server, and
+// kicks the client with the ID if there is one. It returns if a client was
+// kicked.
+func (s *Server) Ban(id string) bool {
+	s.clientsMu.Lock()
+	if s.banned == nil {
+		s.banned = make(map[string]bool)
+	}
+	s.banned[id] = true
+	s.clientsMu.Unlock()
+
+	return s.Kick(id)
+}
+
+// Unban lifts the ban on the given client ID. false is returned if the ID was
+// not banned.
+func (s *Server) Unban(id string) bool {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	if !s.banned[id] {
+		return false
+	}
+	delete(s.banned, id)
+	return true
+}
+
+// Banned returns the sorted list of banned client IDs.
+func (s *Server) Banned() []string {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	ids := make([]string, 0, len(s.banned))
+	for id := range s.banned {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// EndRound ends the round in progress as if it had run out of time: the
+// longest snake, if there is one, wins. false is returned if there is no
+// round in progress.
+func (s *Server) EndRound() bool {
+	return s.sendRoundCommand(endRound)
+}
+
+// RestartRound ends the round in progress without a winner and starts a new
+// round, once ServerConfig.PreRoundWait has elapsed. The RoundOverMessage is
+// marked as aborted, and the round's replay is discarded. false is returned
+// if there is no round in progress.
+func (s *Server) RestartRound() bool {
+	return s.sendRoundCommand(restartRound)
+}
+
+// sendRoundCommand passes the command to the round in progress.
+func (s *Server) sendRoundCommand(cmd roundCommand) bool {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+
+	if !s.roundActive {
+		return false
+	}
+	select {
+	case s.roundCommands <- cmd:
+	default:
+		// A command is already waiting to be handled
+	}
+	return true
+}
+
+// setRoundActive records if a round is in progress. Commands left over from
+// the previous round are discarded.
+func (s *Server) setRoundActive(active bool) {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+
+	s.roundActive = active
+	select {
+	case <-s.roundCommands:
+	default:
+	}
+}
+
+// Pause pauses the game loop. The round in progress stops ticking, and no
+// new round is started, until Resume is called; the round's next tick is then
+// a full ServerConfig.RoundTick later. The round's time limit keeps running
+// while it is paused. A stopped server can not be paused.
+func (s *Server) Pause() {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+
+	if s.resumed == nil && !s.isStopping() {
+		s.resumed = make(chan struct{})
+	}
+}
+
+// Resume resumes the game loop after Pause.
+func (s *Server) Resume() {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+
+	if s.resumed != nil {
+		close(s.resumed)
+		s.resumed = nil
+	}
+}
+
+// pausedChan returns a channel that is closed once the game loop is resumed,
+// or nil if the game loop is not paused.
+func (s *Server) pausedChan() <-chan struct{} {
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+	return s.resumed
+}
+
+// Config returns the configuration that the server is using.
+func (s *Server) Config() ServerConfig {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	return s.config
+}
+
+// UpdateConfig changes the server's configuration. update is called with a
+// copy of the configuration, including changes that have not been applied
+// yet, and the result is returned. The server starts using it before the next
+// round begins; the round in progress is not affected.
+//
+// Changes to Seed have no effect. Changes to SendQueueSize and SlowConsumer
+// only apply to clients and viewers that are added afterwards.
+func (s *Server) UpdateConfig(update func(*ServerConfig)) ServerConfig {
+	s.configMu.Lock()
+	config := s.config
+	if s.pendingConfig != nil {
+		config = *s.pendingConfig
+	}
+	update(&config)
+	s.pendingConfig = &config
+	s.configMu.Unlock()
+
+	// Wake up the game loop if it is waiting for clients, in case
+	// MinimumClients changed
+	s.signalClientsUpdated()
+	return config
+}
+
+// applyConfig starts using the configuration set by UpdateConfig. Only called
+// by Run.
+func (s *Server) applyConfig() {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	if s.pendingConfig != nil {
+		s.config = *s.pendingConfig
+		s.pendingConfig = nil
+	}
+}
@@ -0,0 +1,224 @@
+package snakes
+
+// deltaKeyframeInterval is the maximum number of RoundDeltaMessages sent
+// between full RoundStateMessages.
+const deltaKeyframeInterval = 20
+
+// RoundDeltaMessage describes the changes between two RoundStateMessages of
+// the same round. It is sent instead of a RoundStateMessage to clients that
+// requested delta updates (see DeltaUpdates). A full RoundStateMessage (a
+// keyframe) is sent at the start of each round, when a client connects,
+// whenever the items in the arena change, and periodically.
+//
+// The full state is reconstructed with RoundStateMessage.ApplyDelta.
+type RoundDeltaMessage struct {
+	Tick int `json:"tick"`
+	// Players whose pieces changed.
+	Players []*RoundDeltaPlayer `json:"players,omitempty"`
+	// The new apple, if it changed.
+	Apple *Apple `json:"apple,omitempty"`
+	// Number of seconds remaining in the round. nil if there is no time limit.
+	SecondsRemaining *int `json:"seconds_remaining,omitempty"`
+}
+
+// RoundDeltaPlayer describes how a player's pieces changed.
+type RoundDeltaPlayer struct {
+	// Index of the player in RoundStateMessage.Players.
+	Index int `json:"index"`
+	// Pieces added to the front of the snake, head first.
+	Heads []Location `json:"heads,omitempty"`
+	// Number of pieces removed from the end of the snake.
+	TailsRemoved int `json:"tails_removed,omitempty"`
+	// If the snake died. All of its pieces are removed.
+	Died bool `json:"died,omitempty"`
+	// The direction that the snake is facing, if it changed.
+	Direction *Direction `json:"direction,omitempty"`
+	// The snake's remaining phase ticks, if they changed.
+	Phase *int `json:"phase,omitempty"`
+}
+
+// ApplyDelta returns the state that results from applying the delta to m. m is
+// not modified.
+//
+// nil is returned if the delta does not apply to m.
+func (m *RoundStateMessage) ApplyDelta(d *RoundDeltaMessage) *RoundStateMessage {
+	next := *m
+	next.Tick = d.Tick
+	next.SecondsRemaining = d.SecondsRemaining
+	if d.Apple != nil {
+		next.Apple = *d.Apple
+	}
+
+	next.Players = make([]*RoundStateMessagePlayer, len(m.Players))
+	copy(next.Players, m.Players)
+	for _, change := range d.Players {
+		if change.Index < 0 || change.Index >= len(next.Players) {
+			return nil
+		}
+		player := next.Players[change.Index]
+		changed := &RoundStateMessagePlayer{
+			Name:      player.Name,
+			Direction: player.Direction,
+			Phase:     player.Phase,
+		}
+		if change.Direction != nil {
+			changed.Direction = *change.Direction
+		}
+		if change.Phase != nil {
+			changed.Phase = *change.Phase
+		}
+		if !change.Died {
+			kept := len(player.Pieces) - change.TailsRemoved
+			if kept < 0 {
+				return nil
+			}
+			changed.Pieces = make([]Location, 0, len(change.Heads)+kept)
+			changed.Pieces = append(changed.Pieces, change.Heads...)
+			changed.Pieces = append(changed.Pieces, player.Pieces[:kept]...)
+		}
+		next.Players[change.Index] = changed
+	}
+	return &next
+}
+
+// deltaEncoder converts the RoundStateMessages sent to a single connection
+// into RoundDeltaMessages.
+type deltaEncoder struct {
+	last          *RoundStateMessage
+	sinceKeyframe int
+}
+
+// encode returns the message to send in place of msg.
+func (e *deltaEncoder) encode(msg *Message) *Message {
+	switch {
+	case msg.RoundStateMessage != nil:
+	case msg.WaitingMessage != nil, msg.RoundPreparation != nil, msg.RoundOverMessage != nil:
+		e.last = nil
+		return msg
+	default:
+		return msg
+	}
+
+	state := msg.RoundStateMessage
+	last := e.last
+	e.last = state
+	if last == nil || e.sinceKeyframe >= deltaKeyframeInterval || !sameArena(last, state) || !equalItems(last.Items, state.Items) {
+		e.sinceKeyframe = 0
+		return msg
+	}
+	e.sinceKeyframe++
+
+	d := &RoundDeltaMessage{
+		Tick:             state.Tick,
+		SecondsRemaining: state.SecondsRemaining,
+	}
+	if last.Apple != state.Apple {
+		apple := state.Apple
+		d.Apple = &apple
+	}
+	for i, player := range state.Players {
+		change := pieceDelta(last.Players[i].Pieces, player.Pieces)
+		if player.Direction != last.Players[i].Direction {
+			if change == nil {
+				change = &RoundDeltaPlayer{}
+			}
+			direction := player.Direction
+			change.Direction = &direction
+		}
+		if player.Phase != last.Players[i].Phase {
+			if change == nil {
+				change = &RoundDeltaPlayer{}
+			}
+			phase := player.Phase
+			change.Phase = &phase
+		}
+		if change != nil {
+			change.Index = i
+			d.Players = append(d.Players, change)
+		}
+	}
+	return &Message{
+		RoundDelta: d,
+	}
+}
+
+// reset causes the next RoundStateMessage to be sent as a keyframe.
+func (e *deltaEncoder) reset() {
+	e.last = nil
+}
+
+// sameArena returns if the states have the same arena size, walls and
+// players, so that a delta between them can be encoded.
+func sameArena(a, b *RoundStateMessage) bool {
+	if a.Width != b.Width || a.Height != b.Height || len(a.Walls) != len(b.Walls) || len(a.Players) != len(b.Players) {
+		return false
+	}
+	for i := range a.Walls {
+		if a.Walls[i] != b.Walls[i] {
+			return false
+		}
+	}
+	for i := range a.Players {
+		if a.Players[i].Name != b.Players[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+// pieceDelta returns the change from the pieces before to the pieces after.
+// nil is returned if the pieces did not change.
+func pieceDelta(before, after []Location) *RoundDeltaPlayer {
+	if len(after) == 0 {
+		if len(before) == 0 {
+			return nil
+		}
+		return &RoundDeltaPlayer{
+			Died: true,
+		}
+	}
+
+	// Find the fewest new heads for which the rest of the snake is the front
+	// of the previous pieces.
+	for heads := 0; heads < len(after); heads++ {
+		kept := len(after) - heads
+		if kept > len(before) || !equalLocations(after[heads:], before[:kept]) {
+			continue
+		}
+		if heads == 0 && kept == len(before) {
+			return nil
+		}
+		return &RoundDeltaPlayer{
+			Heads:        after[:heads],
+			TailsRemoved: len(before) - kept,
+		}
+	}
+	return &RoundDeltaPlayer{
+		Heads:        after,
+		TailsRemoved: len(before),
+	}
+}
+
+func equalLocations(a, b []Location) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalItems(a, b []Item) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
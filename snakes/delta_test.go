@@ -0,0 +1,105 @@
+package snakes
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestApplyDeltaRoundTrip(t *testing.T) {
+	state, err := NewState(StateConfig{
+		Width:              20,
+		Height:             10,
+		SnakeCount:         4,
+		InitialSnakeLength: 5,
+		Seed:               1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"a", "b", "c", "d"}
+	rng := rand.New(rand.NewSource(1))
+
+	var e deltaEncoder
+	var received *RoundStateMessage
+	deltas := 0
+	for tick := 0; tick < 50; tick++ {
+		seconds := 50 - tick
+		want := roundStateMessageFromState(names, state)
+		want.SecondsRemaining = &seconds
+
+		msg := e.encode(&Message{RoundStateMessage: want})
+		switch {
+		case msg.RoundStateMessage != nil:
+			received = msg.RoundStateMessage
+		case msg.RoundDelta != nil:
+			deltas++
+			received = received.ApplyDelta(msg.RoundDelta)
+			if received == nil {
+				t.Fatalf("tick %d: delta does not apply", tick)
+			}
+		default:
+			t.Fatalf("tick %d: unexpected message %+v", tick, msg)
+		}
+		if !reflect.DeepEqual(received, want) {
+			t.Fatalf("tick %d: got %+v, want %+v", tick, received, want)
+		}
+
+		directions := make([]Direction, len(state.Snakes))
+		for i, snake := range state.Snakes {
+			directions[i] = snake.Direction
+			if rng.Intn(3) == 0 {
+				directions[i] = allDirections[rng.Intn(len(allDirections))]
+			}
+		}
+		state = state.Next(directions)
+	}
+
+	if deltas == 0 {
+		t.Error("no deltas were sent")
+	}
+}
+
+func TestApplyDeltaInvalid(t *testing.T) {
+	m := &RoundStateMessage{
+		Tick: 1,
+		Players: []*RoundStateMessagePlayer{
+			{Name: "a", Pieces: []Location{{1, 1}, {1, 2}}},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		delta *RoundDeltaMessage
+	}{
+		{
+			name: "player index out of range",
+			delta: &RoundDeltaMessage{
+				Tick:    2,
+				Players: []*RoundDeltaPlayer{{Index: 1}},
+			},
+		},
+		{
+			name: "negative player index",
+			delta: &RoundDeltaMessage{
+				Tick:    2,
+				Players: []*RoundDeltaPlayer{{Index: -1}},
+			},
+		},
+		{
+			name: "too many tails removed",
+			delta: &RoundDeltaMessage{
+				Tick:    2,
+				Players: []*RoundDeltaPlayer{{Index: 0, TailsRemoved: 3}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := m.ApplyDelta(test.delta); got != nil {
+				t.Errorf("got %+v, want nil", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package snakes
+
+import (
+	"time"
+)
+
+// historyRounds is the number of rounds kept in a Server's round history.
+const historyRounds = 10
+
+// historyRoundMessages is the maximum number of messages kept for each round
+// in a Server's round history. Once a round reaches it, its oldest messages are
+// dropped, so that rounds without a time limit do not grow without bound.
+const historyRoundMessages = 3000
+
+// RoundHistory provides the messages broadcast during recently played rounds.
+type RoundHistory interface {
+	// RoundIDs returns the IDs of the rounds in the history, oldest first.
+	// The last round may still be in progress.
+	RoundIDs() []int
+
+	// RoundMessages returns the RoundStateMessages and RoundOverMessage
+	// broadcast during the round with the given ID, in the order they were
+	// broadcast. Only the latest messages of long rounds are kept. nil is
+	// returned if the round is not in the history.
+	RoundMessages(id int) []*Message
+
+	// RoundTick returns the duration of a round tick.
+	RoundTick() time.Duration
+}
+
+var _ RoundHistory = (*Server)(nil)
+
+// roundRecord contains the messages broadcast during a round.
+type roundRecord struct {
+	id       int
+	messages []*Message
+}
+
+// startHistory starts recording a new round in the server's history.
+func (s *Server) startHistory() {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	s.nextRoundID++
+	s.history = append(s.history, &roundRecord{
+		id: s.nextRoundID,
+	})
+	if len(s.history) > historyRounds {
+		s.history = s.history[len(s.history)-historyRounds:]
+	}
+	s.historyActive = true
+}
+
+// addHistory adds the message to the history of the round in progress.
+func (s *Server) addHistory(msg *Message) {
+	if msg.RoundStateMessage == nil && msg.RoundOverMessage == nil {
+		return
+	}
+
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	if !s.historyActive {
+		return
+	}
+	current := s.history[len(s.history)-1]
+	if len(current.messages) >= historyRoundMessages {
+		n := copy(current.messages, current.messages[1:])
+		current.messages[n] = nil
+		current.messages = current.messages[:n]
+	}
+	current.messages = append(current.messages, msg)
+	if msg.RoundOverMessage != nil {
+		s.historyActive = false
+	}
+}
+
+// RoundIDs implements RoundHistory.
+func (s *Server) RoundIDs() []int {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	ids := make([]int, len(s.history))
+	for i, round := range s.history {
+		ids[i] = round.id
+	}
+	return ids
+}
+
+// RoundMessages implements RoundHistory.
+func (s *Server) RoundMessages(id int) []*Message {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	for _, round := range s.history {
+		if round.id == id {
+			messages := make([]*Message, len(round.messages))
+			copy(messages, round.messages)
+			return messages
+		}
+	}
+	return nil
+}
+
+// RoundTick implements RoundHistory.
+func (s *Server) RoundTick() time.Duration {
+	return s.Config().RoundTick
+}
@@ -0,0 +1,161 @@
+package snakes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ItemType is the type of an item that can be picked up by snakes.
+type ItemType string
+
+// Item types.
+const (
+	// An apple grows the snake by one piece, like State.Apple.
+	ItemApple ItemType = "apple"
+	// A golden apple grows the snake by three pieces.
+	ItemGoldenApple ItemType = "golden_apple"
+	// A shrink pill removes two pieces from the snake. A snake never
+	// shrinks below one piece.
+	ItemShrink ItemType = "shrink"
+	// A phase power-up lets the snake move through snake bodies for
+	// PhaseDuration ticks. Head-on collisions, walls and the arena edge
+	// are still deadly.
+	ItemPhase ItemType = "phase"
+)
+
+// PhaseDuration is the number of ticks that the phase power-up lasts.
+const PhaseDuration = 10
+
+// ItemTypes contains every item type.
+var ItemTypes = []ItemType{ItemApple, ItemGoldenApple, ItemShrink, ItemPhase}
+
+// Item is an item in the arena.
+type Item struct {
+	Type     ItemType `json:"type"`
+	Location Location `json:"location"`
+	// Tick at which the item decays and is removed from the arena. Zero if
+	// the item does not decay.
+	ExpiresTick int `json:"expires_tick,omitempty"`
+}
+
+// ItemConfig configures a type of item that is kept in the arena, in
+// addition to State.Apple.
+type ItemConfig struct {
+	Type ItemType `json:"type"`
+	// Number of items of the type in the arena at once. A new item is
+	// placed whenever one is picked up or decays.
+	Count int `json:"count"`
+	// Number of ticks that each item lasts before decaying. If unset, items
+	// do not decay.
+	Lifetime int `json:"lifetime,omitempty"`
+}
+
+// ParseItemConfig parses an item configuration of the form
+// type:count[:lifetime], e.g. golden_apple:1 or shrink:2:50.
+func ParseItemConfig(value string) (ItemConfig, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return ItemConfig{}, fmt.Errorf("invalid item %q", value)
+	}
+
+	cfg := ItemConfig{
+		Type: ItemType(parts[0]),
+	}
+	if !validItemType(cfg.Type) {
+		return ItemConfig{}, fmt.Errorf("unknown item type %q", parts[0])
+	}
+	var err error
+	if cfg.Count, err = strconv.Atoi(parts[1]); err != nil || cfg.Count < 0 {
+		return ItemConfig{}, fmt.Errorf("invalid item count %q", parts[1])
+	}
+	if len(parts) == 3 {
+		if cfg.Lifetime, err = strconv.Atoi(parts[2]); err != nil || cfg.Lifetime < 0 {
+			return ItemConfig{}, fmt.Errorf("invalid item lifetime %q", parts[2])
+		}
+	}
+	return cfg, nil
+}
+
+func validItemType(t ItemType) bool {
+	for _, itemType := range ItemTypes {
+		if t == itemType {
+			return true
+		}
+	}
+	return false
+}
+
+// pickUp applies the effect of picking up the item to the snake.
+func (i Item) pickUp(snake *Snake) {
+	switch i.Type {
+	case ItemApple:
+		snake.Length++
+	case ItemGoldenApple:
+		snake.Length += 3
+	case ItemShrink:
+		snake.Length -= 2
+		if snake.Length < 1 {
+			snake.Length = 1
+		}
+	case ItemPhase:
+		snake.Phase = PhaseDuration
+	}
+}
+
+// refillItems removes decayed items, then places new items until the arena
+// has the number of items of each type given by the state's item
+// configuration.
+func (s *State) refillItems() {
+	items := s.Items[:0:0]
+	for _, item := range s.Items {
+		if item.ExpiresTick == 0 || item.ExpiresTick > s.Tick {
+			items = append(items, item)
+		}
+	}
+	s.Items = items
+
+	for configNo, cfg := range s.ItemConfigs {
+		count := 0
+		for _, item := range s.Items {
+			if item.Type == cfg.Type {
+				count++
+			}
+		}
+
+		for ; count < cfg.Count; count++ {
+			// salt distinguishes between the items placed during the same
+			// tick
+			salt := int64(configNo+1)<<32 | int64(count)
+			location, ok := s.place(s.Seed^salt, s.occupied())
+			if !ok {
+				// No room for more items
+				return
+			}
+			item := Item{
+				Type:     cfg.Type,
+				Location: location,
+			}
+			if cfg.Lifetime > 0 {
+				item.ExpiresTick = s.Tick + cfg.Lifetime
+			}
+			s.Items = append(s.Items, item)
+		}
+	}
+}
+
+// occupied returns the locations of the walls, the apple and the items,
+// which new apples and items must avoid.
+func (s *State) occupied() []Location {
+	if len(s.Items) == 0 && len(s.ItemConfigs) == 0 {
+		return s.Walls
+	}
+
+	occupied := make([]Location, 0, len(s.Walls)+len(s.Items)+1)
+	occupied = append(occupied, s.Walls...)
+	occupied = append(occupied, s.Apple.Location)
+	for _, item := range s.Items {
+		occupied = append(occupied, item.Location)
+	}
+	return occupied
+}
@@ -0,0 +1,112 @@
+package snakes
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Map is a fixed arena layout.
+//
+// Maps are stored as text files, with one line per row of the arena. Each
+// character of a line is a cell:
+//   - '#' is a wall
+//   - '.' or ' ' is an empty cell
+//   - '*' is an empty cell in which the apple can be placed
+//   - '^', '>', 'v' and '<' are spawn points facing north, east, south and
+//     west. Snakes are assigned to spawn points in reading order.
+//
+// The width of the arena is the length of the longest line. If the map has
+// any '*' cells, the apple is only placed in those cells.
+type Map struct {
+	Name          string
+	Width, Height int
+	Walls         []Location
+	Spawns        []Spawn
+	AppleZones    []Location
+}
+
+// LoadMap reads the map file at path. The map is named after the file's
+// base name, without its extension.
+func LoadMap(path string) (*Map, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := ParseMap(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	m.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return m, nil
+}
+
+// ParseMap parses a map in the text format described by Map.
+func ParseMap(r io.Reader) (*Map, error) {
+	m := &Map{}
+
+	scanner := bufio.NewScanner(r)
+	var rows []string
+	for scanner.Scan() {
+		rows = append(rows, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for len(rows) > 0 && strings.TrimSpace(rows[len(rows)-1]) == "" {
+		rows = rows[:len(rows)-1]
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("empty map")
+	}
+
+	m.Height = len(rows)
+	for y, row := range rows {
+		if n := utf8.RuneCountInString(row); n > m.Width {
+			m.Width = n
+		}
+
+		x := 0
+		for _, c := range row {
+			l := Location{X: x, Y: y}
+			switch c {
+			case '#':
+				m.Walls = append(m.Walls, l)
+			case '.', ' ':
+			case '*':
+				m.AppleZones = append(m.AppleZones, l)
+			case '^':
+				m.Spawns = append(m.Spawns, Spawn{Location: l, Direction: DirectionNorth})
+			case '>':
+				m.Spawns = append(m.Spawns, Spawn{Location: l, Direction: DirectionEast})
+			case 'v':
+				m.Spawns = append(m.Spawns, Spawn{Location: l, Direction: DirectionSouth})
+			case '<':
+				m.Spawns = append(m.Spawns, Spawn{Location: l, Direction: DirectionWest})
+			default:
+				return nil, fmt.Errorf("line %d: invalid map cell %q", y+1, c)
+			}
+			x++
+		}
+	}
+	return m, nil
+}
+
+// Apply sets the arena size, walls, apple zones and spawn points of the state
+// configuration to those of the map.
+func (m *Map) Apply(cfg *StateConfig) {
+	cfg.Width = m.Width
+	cfg.Height = m.Height
+	cfg.Walls = m.Walls
+	cfg.AppleZones = m.AppleZones
+	if len(m.Spawns) > 0 {
+		cfg.Spawn = PositionSpawn{Spawns: m.Spawns}
+	}
+}
@@ -0,0 +1,225 @@
+package snakes
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+	"math"
+	"math/rand"
+)
+
+// PlacementPolicy decides where apples and items are placed in the arena.
+type PlacementPolicy interface {
+	// Place returns one of the free locations. free contains at least one
+	// location, and only contains cells that are inside of the arena and
+	// are not occupied by a snake, a wall, the apple or an item.
+	//
+	// Every random decision must be made with rng, so that states created
+	// with the same seed are identical.
+	Place(s *State, free []Location, rng *rand.Rand) Location
+}
+
+var (
+	_ PlacementPolicy = UniformPlacement{}
+	_ PlacementPolicy = DistantPlacement{}
+	_ PlacementPolicy = FairPlacement{}
+)
+
+// PlacementByName returns the built-in placement policy with the given name
+// (uniform, distant or fair). false is returned if there is no such policy.
+func PlacementByName(name string) (PlacementPolicy, bool) {
+	switch name {
+	case "uniform":
+		return UniformPlacement{}, true
+	case "distant":
+		return DistantPlacement{}, true
+	case "fair":
+		return FairPlacement{}, true
+	}
+	return nil, false
+}
+
+// PlacementName returns the name of the given built-in placement policy. nil
+// is treated as UniformPlacement. false is returned if p is not a built-in
+// policy, or is one with non-default settings.
+func PlacementName(p PlacementPolicy) (string, bool) {
+	switch p := p.(type) {
+	case nil, UniformPlacement:
+		return "uniform", true
+	case DistantPlacement:
+		return "distant", p.MinDistance == 0
+	case FairPlacement:
+		return "fair", true
+	}
+	return "", false
+}
+
+// UniformPlacement picks any free location, with equal probability.
+type UniformPlacement struct{}
+
+// Place implements PlacementPolicy.
+func (UniformPlacement) Place(s *State, free []Location, rng *rand.Rand) Location {
+	return free[rng.Intn(len(free))]
+}
+
+// defaultPlacementDistance is the minimum distance used by DistantPlacement
+// when one is not set.
+const defaultPlacementDistance = 4
+
+// DistantPlacement picks a free location that is at least a minimum
+// Manhattan distance away from the head of every alive snake. If there is no
+// such location, the locations furthest from the nearest head are used.
+type DistantPlacement struct {
+	// Minimum distance from every snake head. If unset, 4 is used.
+	MinDistance int
+}
+
+// Place implements PlacementPolicy.
+func (p DistantPlacement) Place(s *State, free []Location, rng *rand.Rand) Location {
+	minDistance := p.MinDistance
+	if minDistance <= 0 {
+		minDistance = defaultPlacementDistance
+	}
+
+	var candidates []Location
+	best := -1
+	for _, l := range free {
+		nearest := math.MaxInt32
+		for _, snake := range s.Snakes {
+			if snake.Alive {
+				if d := distance(l, snake.Pieces[0]); d < nearest {
+					nearest = d
+				}
+			}
+		}
+		if nearest > minDistance {
+			nearest = minDistance
+		}
+
+		if nearest > best {
+			candidates, best = candidates[:0], nearest
+		}
+		if nearest == best {
+			candidates = append(candidates, l)
+		}
+	}
+	return candidates[rng.Intn(len(candidates))]
+}
+
+// FairPlacement picks a free location that is as close as possible to being
+// the same distance away from the head of every alive snake, so that no
+// snake has a head start in reaching it.
+type FairPlacement struct{}
+
+// Place implements PlacementPolicy.
+func (FairPlacement) Place(s *State, free []Location, rng *rand.Rand) Location {
+	var candidates []Location
+	best := math.MaxInt32
+	for _, l := range free {
+		nearest, furthest := math.MaxInt32, 0
+		for _, snake := range s.Snakes {
+			if snake.Alive {
+				d := distance(l, snake.Pieces[0])
+				if d < nearest {
+					nearest = d
+				}
+				if d > furthest {
+					furthest = d
+				}
+			}
+		}
+		spread := 0
+		if nearest <= furthest {
+			spread = furthest - nearest
+		}
+
+		if spread < best {
+			candidates, best = candidates[:0], spread
+		}
+		if spread == best {
+			candidates = append(candidates, l)
+		}
+	}
+	return candidates[rng.Intn(len(candidates))]
+}
+
+// placementPolicy returns the state's placement policy, or UniformPlacement
+// if one is not set.
+func (s *State) placementPolicy() PlacementPolicy {
+	if s.Placement == nil {
+		return UniformPlacement{}
+	}
+	return s.Placement
+}
+
+// place returns a location for a new apple or item that avoids the snakes and
+// the occupied locations, chosen by the state's placement policy. If the
+// state has apple zones, a free zone location is chosen, unless they are all
+// occupied. The random choices are derived from the seed and the location of
+// the snake heads.
+//
+// false is returned if there is no free location in the arena.
+func (s *State) place(seed int64, occupied []Location) (Location, bool) {
+	free := freeLocations(s.Width, s.Height, s.Snakes, occupied, s.AppleZones)
+	if len(free) == 0 {
+		return Location{}, false
+	}
+	return s.placementPolicy().Place(s, free, placementRand(seed, s.Snakes)), true
+}
+
+// placementRand returns the source of randomness used to place an apple or
+// item.
+func placementRand(seed int64, snakes []*Snake) *rand.Rand {
+	h := crc64.New(crc64.MakeTable(crc64.ISO))
+
+	var seedBytes [8]byte
+	binary.BigEndian.PutUint64(seedBytes[:], uint64(seed))
+	h.Write(seedBytes[:])
+
+	for _, snake := range snakes {
+		var b [16]byte
+		var x, y uint64
+		if snake.Alive {
+			x, y = uint64(snake.Pieces[0].X), uint64(snake.Pieces[0].Y)
+		}
+		binary.BigEndian.PutUint64(b[:8], x)
+		binary.BigEndian.PutUint64(b[8:], y)
+		h.Write(b[:])
+	}
+
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// freeLocations returns the locations in the arena that are not occupied by
+// a snake or one of the occupied locations, in row order. If any of the zone
+// locations are free, only those are returned.
+func freeLocations(width, height int, snakes []*Snake, occupied, zones []Location) []Location {
+	taken := make(map[Location]bool, len(occupied))
+	for _, l := range occupied {
+		taken[l] = true
+	}
+	for _, snake := range snakes {
+		for _, piece := range snake.Pieces {
+			taken[piece] = true
+		}
+	}
+
+	var free []Location
+	for _, l := range zones {
+		if l.IsInsideBounds(width, height) && !taken[l] {
+			taken[l] = true
+			free = append(free, l)
+		}
+	}
+	if len(free) > 0 {
+		return free
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if l := (Location{X: x, Y: y}); !taken[l] {
+				free = append(free, l)
+			}
+		}
+	}
+	return free
+}
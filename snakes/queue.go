@@ -0,0 +1,205 @@
+package snakes
+
+import (
+	"errors"
+	"sync"
+)
+
+// DefaultSendQueueSize is the default number of messages queued for each
+// client and viewer of a Server.
+const DefaultSendQueueSize = 16
+
+// ErrSlowConsumer is the error reported for a client or viewer that is
+// disconnected by the DisconnectSlowConsumer policy.
+var ErrSlowConsumer = errors.New("send queue is full")
+
+// SlowConsumerPolicy decides what a Server does when the send queue of a
+// client or viewer is full because it is not receiving messages as fast as
+// they are broadcast.
+//
+// Only round states and deltas are ever dropped: the other messages, such as
+// RoundOverMessage and SessionMessage, are always delivered. If the queue is
+// full and none of its messages can be dropped, the client or viewer is
+// disconnected.
+type SlowConsumerPolicy int
+
+// Slow consumer policies.
+const (
+	// DropOldest discards the oldest queued round state to make room for
+	// the new message. Round states are superseded by the next tick, so slow
+	// viewers skip ticks rather than fall behind.
+	DropOldest SlowConsumerPolicy = iota
+	// DropNewest discards the new message if it is a round state, or the
+	// oldest queued round state otherwise.
+	DropNewest
+	// DisconnectSlowConsumer discards the queued messages and disconnects
+	// the client or viewer.
+	DisconnectSlowConsumer
+)
+
+// SlowConsumerPolicyByName returns the policy with the given name
+// (drop-oldest, drop-newest or disconnect). false is returned if there is no
+// such policy.
+func SlowConsumerPolicyByName(name string) (SlowConsumerPolicy, bool) {
+	switch name {
+	case "drop-oldest":
+		return DropOldest, true
+	case "drop-newest":
+		return DropNewest, true
+	case "disconnect":
+		return DisconnectSlowConsumer, true
+	}
+	return 0, false
+}
+
+// sendQueue is a bounded queue of the messages sent to a client or viewer.
+// The messages are sent by the queue's own goroutine, so that a slow
+// connection does not hold up the server.
+type sendQueue struct {
+	target ViewerClient
+	size   int
+	policy SlowConsumerPolicy
+	// onError is called by the queue's goroutine with the error returned by
+	// target.SendMessage, or ErrSlowConsumer.
+	onError func(error)
+
+	mu       sync.Mutex
+	messages []*Message
+	err      error
+	closed   bool
+	draining bool
+	wake     chan struct{}
+	// done is closed once the queue's goroutine returns.
+	done chan struct{}
+}
+
+// newSendQueue creates a send queue for target and starts its goroutine.
+func newSendQueue(target ViewerClient, size int, policy SlowConsumerPolicy, onError func(error)) *sendQueue {
+	if size <= 0 {
+		size = DefaultSendQueueSize
+	}
+	q := &sendQueue{
+		target:  target,
+		size:    size,
+		policy:  policy,
+		onError: onError,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// droppable returns if the message can be discarded by a SlowConsumerPolicy.
+func droppable(msg *Message) bool {
+	return msg.RoundStateMessage != nil || msg.RoundDelta != nil
+}
+
+// push queues the message without blocking. If the queue is full, the
+// queue's SlowConsumerPolicy is applied.
+func (q *sendQueue) push(msg *Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	if len(q.messages) >= q.size {
+		switch {
+		case q.policy == DropNewest && droppable(msg):
+			return
+		case q.policy != DisconnectSlowConsumer && q.dropOldest():
+		default:
+			q.messages = nil
+			q.err = ErrSlowConsumer
+			q.signal()
+			return
+		}
+	}
+	q.messages = append(q.messages, msg)
+	q.signal()
+}
+
+// dropOldest removes the oldest droppable message from the queue. false is
+// returned if there is none. q.mu must be held.
+func (q *sendQueue) dropOldest() bool {
+	for i, msg := range q.messages {
+		if droppable(msg) {
+			n := copy(q.messages[i:], q.messages[i+1:])
+			q.messages[i+n] = nil
+			q.messages = q.messages[:i+n]
+			return true
+		}
+	}
+	return false
+}
+
+// len returns the number of queued messages.
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
+
+// close stops the queue's goroutine. Messages that have not been sent are
+// discarded.
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.messages = nil
+	q.signal()
+}
+
+// drain causes the queue's goroutine to return once the queued messages have
+// been sent.
+func (q *sendQueue) drain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.draining = true
+	q.signal()
+}
+
+// signal wakes up the queue's goroutine. q.mu must be held.
+func (q *sendQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the queued messages until the queue is closed, or is drained.
+func (q *sendQueue) run() {
+	defer close(q.done)
+
+	for range q.wake {
+		for {
+			q.mu.Lock()
+			if q.closed || (q.draining && q.err == nil && len(q.messages) == 0) {
+				q.mu.Unlock()
+				return
+			}
+			err := q.err
+			q.err = nil
+			var msg *Message
+			if err == nil && len(q.messages) > 0 {
+				msg = q.messages[0]
+				q.messages[0] = nil
+				q.messages = q.messages[1:]
+			}
+			q.mu.Unlock()
+
+			if err == nil && msg == nil {
+				break
+			}
+			if err == nil {
+				err = q.target.SendMessage(msg)
+			}
+			if err != nil {
+				q.onError(err)
+			}
+		}
+	}
+}
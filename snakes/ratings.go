@@ -0,0 +1,214 @@
+package snakes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Default Elo rating values.
+const (
+	InitialRating = 1500
+	RatingKFactor = 32
+)
+
+// Rating is a bot's rating and round history.
+type Rating struct {
+	Name   string  `json:"name"`
+	Rating float64 `json:"rating"`
+	Rounds int     `json:"rounds"`
+	Wins   int     `json:"wins"`
+	Losses int     `json:"losses"`
+	Draws  int     `json:"draws"`
+}
+
+// Ratings tracks the Elo ratings of bots across rounds, and persists them
+// to a JSON file.
+//
+// Multiplayer rounds are rated as a series of pairwise matches between every
+// participant: the winner of a round beats every other participant, and a
+// round without a winner is a draw between all participants.
+type Ratings struct {
+	mu      sync.Mutex
+	path    string
+	ratings map[string]*Rating
+}
+
+// NewFileRatings creates a Ratings that is stored in the file at path. The
+// existing ratings are loaded from the file if it exists.
+func NewFileRatings(path string) (*Ratings, error) {
+	r := &Ratings{
+		path:    path,
+		ratings: make(map[string]*Rating),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, err
+	}
+
+	var ratings []*Rating
+	if err := json.Unmarshal(b, &ratings); err != nil {
+		return nil, err
+	}
+	for _, rating := range ratings {
+		r.ratings[rating.Name] = rating
+	}
+	return r, nil
+}
+
+// List returns every rating, highest rating first.
+func (r *Ratings) List() []Rating {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.list()
+}
+
+func (r *Ratings) list() []Rating {
+	list := make([]Rating, 0, len(r.ratings))
+	for _, rating := range r.ratings {
+		list = append(list, *rating)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Rating != list[j].Rating {
+			return list[i].Rating > list[j].Rating
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// get returns the rating for the given bot, creating it if needed.
+func (r *Ratings) get(name string) *Rating {
+	rating, ok := r.ratings[name]
+	if !ok {
+		rating = &Rating{
+			Name:   name,
+			Rating: InitialRating,
+		}
+		r.ratings[name] = rating
+	}
+	return rating
+}
+
+// RecordRound updates the ratings with the result of a round between the
+// given players. winner is nil if there was no winner.
+//
+// The updated ratings are written to the ratings file.
+func (r *Ratings) RecordRound(players []string, winner *string) error {
+	if len(players) < 2 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	score := func(name string) float64 {
+		switch {
+		case winner == nil:
+			return 0.5
+		case *winner == name:
+			return 1
+		}
+		return 0
+	}
+
+	deltas := make([]float64, len(players))
+	for i, a := range players {
+		ra := r.get(a).Rating
+		for j, b := range players {
+			if i == j {
+				continue
+			}
+			rb := r.get(b).Rating
+			expected := 1 / (1 + math.Pow(10, (rb-ra)/400))
+			actual := 0.5
+			if sa, sb := score(a), score(b); sa != sb {
+				actual = sa
+			}
+			deltas[i] += RatingKFactor * (actual - expected) / float64(len(players)-1)
+		}
+	}
+
+	for i, name := range players {
+		rating := r.get(name)
+		rating.Rating += deltas[i]
+		rating.Rounds++
+		switch score(name) {
+		case 1:
+			rating.Wins++
+		case 0:
+			rating.Losses++
+		default:
+			rating.Draws++
+		}
+	}
+
+	return r.save()
+}
+
+// save writes the ratings to the ratings file.
+func (r *Ratings) save() error {
+	b, err := json.MarshalIndent(r.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// Viewer returns a ViewerClient that records the result of every round
+// broadcast by the server it is added to. A separate viewer must be created
+// for each server.
+func (r *Ratings) Viewer() ViewerClient {
+	return &ratingsViewer{
+		r: r,
+	}
+}
+
+// ratingsViewer is a ViewerClient that records round results in Ratings.
+type ratingsViewer struct {
+	r       *Ratings
+	players []string
+}
+
+var _ ViewerClient = (*ratingsViewer)(nil)
+
+// SendMessage implements ViewerClient.
+func (v *ratingsViewer) SendMessage(msg *Message) error {
+	switch {
+	case msg == nil:
+	case msg.RoundStateMessage != nil:
+		if v.players == nil {
+			v.players = make([]string, len(msg.RoundStateMessage.Players))
+			for i, player := range msg.RoundStateMessage.Players {
+				v.players[i] = player.Name
+			}
+		}
+	case msg.RoundOverMessage != nil:
+		players := v.players
+		v.players = nil
+		if players != nil && !msg.RoundOverMessage.Aborted {
+			// A failed save is not returned, as the server would stop
+			// sending results to the viewer. The ratings are kept in
+			// memory and written with the next round.
+			if err := v.r.RecordRound(players, msg.RoundOverMessage.Winner); err != nil {
+				log.Printf("could not save ratings: %s", err)
+			}
+		}
+	default:
+		v.players = nil
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package snakes
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Replay files are JSON-lines files. Each line is a replayLine with a single
+// non-nil field. The first line contains the ReplayHeader, followed by one
+// line per tick, and optionally a final line with the round result.
+
+// ReplayHeader contains everything needed to re-create the initial state
+// of a recorded round.
+type ReplayHeader struct {
+	Width              int        `json:"width"`
+	Height             int        `json:"height"`
+	InitialSnakeLength int        `json:"initial_snake_length"`
+	Ruleset            string     `json:"ruleset"`
+	Walls              []Location `json:"walls"`
+	// Locations in which the apple can be placed. If unset, the apple can be
+	// placed anywhere.
+	AppleZones []Location `json:"apple_zones,omitempty"`
+	// Items kept in the arena in addition to the apple.
+	Items []ItemConfig `json:"items,omitempty"`
+	// Name of the policy used to place the apple and items. If unset,
+	// UniformPlacement is used.
+	Placement string `json:"placement,omitempty"`
+	// Starting location and direction of each snake, in snake number order.
+	// If unset, LineSpawn is used.
+	Spawns []Spawn `json:"spawns,omitempty"`
+	Seed   int64   `json:"seed"`
+	// Player names, in snake number order.
+	Players []string `json:"players"`
+	// Duration of a round tick when the round was recorded.
+	RoundTick time.Duration `json:"round_tick"`
+}
+
+// StateConfig returns the configuration used to create the initial state
+// of the recorded round.
+//
+// An error is returned if the header's rule set or placement policy is not
+// a built-in one.
+func (h *ReplayHeader) StateConfig() (StateConfig, error) {
+	ruleset, ok := RulesetByName(h.Ruleset)
+	if !ok {
+		return StateConfig{}, errors.New("unknown ruleset")
+	}
+	var placement PlacementPolicy
+	if h.Placement != "" {
+		if placement, ok = PlacementByName(h.Placement); !ok {
+			return StateConfig{}, errors.New("unknown placement policy")
+		}
+	}
+	var walls []Location
+	if _, ok := ruleset.(WallsRuleset); ok {
+		ruleset = WallsRuleset{Walls: h.Walls}
+	} else {
+		walls = h.Walls
+	}
+
+	cfg := StateConfig{
+		Width:              h.Width,
+		Height:             h.Height,
+		SnakeCount:         len(h.Players),
+		InitialSnakeLength: h.InitialSnakeLength,
+		Ruleset:            ruleset,
+		Walls:              walls,
+		AppleZones:         h.AppleZones,
+		Items:              h.Items,
+		Placement:          placement,
+		Seed:               h.Seed,
+	}
+	if len(h.Spawns) > 0 {
+		cfg.Spawn = PositionSpawn{Spawns: h.Spawns}
+	}
+	return cfg, nil
+}
+
+// validate returns an error if a state can not be created from the header.
+func (h *ReplayHeader) validate() error {
+	if len(h.Players) < 2 {
+		return errors.New("replay has fewer than two players")
+	}
+	if h.Width <= 0 || h.Height <= 0 {
+		return errors.New("invalid replay arena size")
+	}
+	if len(h.Spawns) > 0 {
+		if len(h.Spawns) != len(h.Players) {
+			return errors.New("replay spawn count does not match player count")
+		}
+		for _, spawn := range h.Spawns {
+			if !spawn.Location.IsInsideBounds(h.Width, h.Height) {
+				return errors.New("replay spawn is outside of the arena")
+			}
+		}
+	}
+	return nil
+}
+
+// replayHeaderFromState creates a ReplayHeader from the initial state of a round.
+func replayHeaderFromState(cfg StateConfig, s *State, players []string, roundTick time.Duration) (*ReplayHeader, error) {
+	ruleset, ok := RulesetName(cfg.Ruleset)
+	if !ok {
+		return nil, errors.New("only built-in rulesets can be recorded")
+	}
+	placement, ok := PlacementName(cfg.Placement)
+	if !ok {
+		return nil, errors.New("only built-in placement policies can be recorded")
+	}
+
+	h := &ReplayHeader{
+		Width:              cfg.Width,
+		Height:             cfg.Height,
+		InitialSnakeLength: cfg.InitialSnakeLength,
+		Ruleset:            ruleset,
+		Walls:              s.Walls,
+		AppleZones:         s.AppleZones,
+		Items:              cfg.Items,
+		Placement:          placement,
+		Seed:               cfg.Seed,
+		Players:            players,
+		RoundTick:          roundTick,
+	}
+	if h.Walls == nil {
+		h.Walls = []Location{}
+	}
+	for _, snake := range s.Snakes {
+		h.Spawns = append(h.Spawns, Spawn{
+			Location:  snake.Pieces[0],
+			Direction: snake.Direction,
+		})
+	}
+	return h, nil
+}
+
+// replayLine is a single line of a replay file.
+type replayLine struct {
+	Header    *ReplayHeader     `json:"header,omitempty"`
+	Tick      *replayTick       `json:"tick,omitempty"`
+	RoundOver *RoundOverMessage `json:"round_over,omitempty"`
+}
+
+// replayTick contains the directions of every snake for a single tick.
+type replayTick struct {
+	Directions []Direction `json:"directions"`
+}
+
+// ReplayWriter writes a replay file.
+type ReplayWriter struct {
+	enc *json.Encoder
+}
+
+// NewReplayWriter creates a new ReplayWriter that writes to w.
+func NewReplayWriter(w io.Writer) *ReplayWriter {
+	return &ReplayWriter{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// WriteHeader writes the replay header. It must be called before any other
+// write method.
+func (r *ReplayWriter) WriteHeader(h *ReplayHeader) error {
+	return r.enc.Encode(&replayLine{Header: h})
+}
+
+// WriteTick writes the directions of every snake for the next tick.
+func (r *ReplayWriter) WriteTick(directions []Direction) error {
+	return r.enc.Encode(&replayLine{Tick: &replayTick{Directions: directions}})
+}
+
+// WriteRoundOver writes the result of the round.
+func (r *ReplayWriter) WriteRoundOver(m *RoundOverMessage) error {
+	return r.enc.Encode(&replayLine{RoundOver: m})
+}
+
+// Replay is a recorded round.
+type Replay struct {
+	Header *ReplayHeader
+	// Directions of every snake for each tick of the round.
+	Ticks [][]Direction
+	// Result of the round. nil if the recording ended before the round was over.
+	RoundOver *RoundOverMessage
+}
+
+// ReadReplay reads a replay file. An error is returned if the header does not
+// describe a playable round, e.g. one with fewer than two players.
+func ReadReplay(r io.Reader) (*Replay, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	replay := &Replay{}
+
+	for {
+		var line replayLine
+		if err := dec.Decode(&line); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		switch {
+		case line.Header != nil:
+			if replay.Header != nil {
+				return nil, errors.New("duplicate replay header")
+			}
+			if err := line.Header.validate(); err != nil {
+				return nil, err
+			}
+			replay.Header = line.Header
+		case replay.Header == nil:
+			return nil, errors.New("missing replay header")
+		case line.Tick != nil:
+			if len(line.Tick.Directions) != len(replay.Header.Players) {
+				return nil, errors.New("invalid replay tick")
+			}
+			replay.Ticks = append(replay.Ticks, line.Tick.Directions)
+		case line.RoundOver != nil:
+			replay.RoundOver = line.RoundOver
+		default:
+			return nil, errors.New("invalid replay line")
+		}
+	}
+
+	if replay.Header == nil {
+		return nil, errors.New("missing replay header")
+	}
+	return replay, nil
+}
+
+// States re-simulates the round and returns the state for every tick,
+// starting with the initial state.
+func (r *Replay) States() ([]*State, error) {
+	cfg, err := r.Header.StateConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	initial, err := NewState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]*State, 1, len(r.Ticks)+1)
+	states[0] = initial
+	for _, directions := range r.Ticks {
+		states = append(states, states[len(states)-1].Next(directions))
+	}
+	return states, nil
+}
+
+// Play re-simulates the round and sends it to the viewer. tick is the amount
+// of time between each state being sent.
+//
+// The function returns when the round has been played back, or on the first
+// error sending a message to the viewer. An error is returned if tick is not
+// positive.
+func (r *Replay) Play(v ViewerClient, tick time.Duration) error {
+	if tick <= 0 {
+		return errors.New("playback tick must be positive")
+	}
+
+	states, err := r.States()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for i, state := range states {
+		if i > 0 {
+			<-ticker.C
+		}
+		msg := &Message{
+			RoundStateMessage: roundStateMessageFromState(r.Header.Players, state),
+		}
+		if err := v.SendMessage(msg); err != nil {
+			return err
+		}
+	}
+
+	if r.RoundOver != nil {
+		<-ticker.C
+		return v.SendMessage(&Message{
+			RoundOverMessage: r.RoundOver,
+		})
+	}
+	return nil
+}
+
+// roundRecorder records a round being played by a Server. A nil
+// *roundRecorder discards everything written to it.
+type roundRecorder struct {
+	f   *os.File
+	w   *ReplayWriter
+	err error
+	// If true, the replay file is removed when the recorder is closed.
+	discarded bool
+}
+
+// startRecording creates a replay file for the round in ServerConfig.RecordDir.
+// nil is returned if the server is not configured to record rounds, or if the
+// replay file could not be created.
+func (s *Server) startRecording(cfg StateConfig, state *State, names []string) *roundRecorder {
+	if s.config.RecordDir == "" {
+		return nil
+	}
+
+	header, err := replayHeaderFromState(cfg, state, names, s.config.RoundTick)
+	if err != nil {
+		log.Printf("could not record round: %s", err)
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%d.jsonl", time.Now().UTC().Format("20060102-150405"), cfg.Seed)
+	f, err := os.Create(filepath.Join(s.config.RecordDir, name))
+	if err != nil {
+		log.Printf("could not record round: %s", err)
+		return nil
+	}
+
+	r := &roundRecorder{
+		f: f,
+		w: NewReplayWriter(f),
+	}
+	r.err = r.w.WriteHeader(header)
+	return r
+}
+
+func (r *roundRecorder) writeTick(directions []Direction) {
+	if r == nil || r.err != nil {
+		return
+	}
+	r.err = r.w.WriteTick(directions)
+}
+
+func (r *roundRecorder) writeRoundOver(m *RoundOverMessage) {
+	if r == nil || r.err != nil {
+		return
+	}
+	r.err = r.w.WriteRoundOver(m)
+}
+
+// discard causes the replay file to be removed when the recorder is closed,
+// for rounds that were aborted.
+func (r *roundRecorder) discard() {
+	if r == nil {
+		return
+	}
+	r.discarded = true
+}
+
+func (r *roundRecorder) close() {
+	if r == nil {
+		return
+	}
+	if err := r.f.Close(); r.err == nil {
+		r.err = err
+	}
+	if r.discarded {
+		if err := os.Remove(r.f.Name()); err != nil {
+			log.Printf("could not remove replay (%s): %s", r.f.Name(), err)
+		}
+		return
+	}
+	if r.err != nil {
+		log.Printf("could not record round (%s): %s", r.f.Name(), r.err)
+	}
+}
@@ -0,0 +1,164 @@
+package snakes
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingViewer is a ViewerClient that records the messages sent to it.
+type recordingViewer struct {
+	messages []*Message
+}
+
+func (v *recordingViewer) SendMessage(msg *Message) error {
+	v.messages = append(v.messages, msg)
+	return nil
+}
+
+// recordTestReplay plays a round with random moves, and returns the replay
+// file along with the state of every tick.
+func recordTestReplay(t *testing.T) ([]byte, []*State, *RoundOverMessage) {
+	cfg := StateConfig{
+		Width:              20,
+		Height:             10,
+		SnakeCount:         3,
+		InitialSnakeLength: 5,
+		Ruleset:            WallsRuleset{},
+		Spawn:              RandomSpawn{},
+		Items: []ItemConfig{
+			{Type: ItemGoldenApple, Count: 1},
+			{Type: ItemShrink, Count: 1, Lifetime: 10},
+		},
+		Seed: 7,
+	}
+	state, err := NewState(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	players := []string{"alice", "bob", "carol"}
+	header, err := replayHeaderFromState(cfg, state, players, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := NewReplayWriter(&buf)
+	if err := w.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	states := []*State{state}
+	for tick := 0; tick < 30; tick++ {
+		directions := make([]Direction, len(players))
+		for i := range directions {
+			directions[i] = allDirections[rng.Intn(len(allDirections))]
+		}
+		if err := w.WriteTick(directions); err != nil {
+			t.Fatal(err)
+		}
+		state = state.Next(directions)
+		states = append(states, state)
+	}
+	rom := &RoundOverMessage{}
+	if winner, ok := state.LongestSnake(); ok {
+		rom.Winner = &players[winner]
+	}
+	if err := w.WriteRoundOver(rom); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), states, rom
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	b, states, rom := recordTestReplay(t)
+
+	replay, err := ReadReplay(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replay.Ticks) != len(states)-1 {
+		t.Fatalf("%d ticks, want %d", len(replay.Ticks), len(states)-1)
+	}
+	if !reflect.DeepEqual(replay.RoundOver, rom) {
+		t.Errorf("RoundOver = %+v, want %+v", replay.RoundOver, rom)
+	}
+
+	replayed, err := replay.States()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != len(states) {
+		t.Fatalf("%d states, want %d", len(replayed), len(states))
+	}
+	for i := range states {
+		got := roundStateMessageFromState(replay.Header.Players, replayed[i])
+		want := roundStateMessageFromState(replay.Header.Players, states[i])
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("tick %d: got %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestReplayPlay(t *testing.T) {
+	b, states, rom := recordTestReplay(t)
+	replay, err := ReadReplay(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v recordingViewer
+	if err := replay.Play(&v, time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.messages) != len(states)+1 {
+		t.Fatalf("%d messages, want %d", len(v.messages), len(states)+1)
+	}
+	for i, state := range states {
+		want := roundStateMessageFromState(replay.Header.Players, state)
+		if !reflect.DeepEqual(v.messages[i].RoundStateMessage, want) {
+			t.Fatalf("message %d: got %+v, want %+v", i, v.messages[i], want)
+		}
+	}
+	if last := v.messages[len(v.messages)-1]; !reflect.DeepEqual(last.RoundOverMessage, rom) {
+		t.Errorf("last message = %+v, want round over %+v", last, rom)
+	}
+
+	if err := replay.Play(&v, 0); err == nil {
+		t.Error("Play with a zero tick did not return an error")
+	}
+}
+
+func TestReadReplayErrors(t *testing.T) {
+	const header = `{"header":{"width":20,"height":10,"initial_snake_length":5,"ruleset":"classic","walls":[],"seed":1,"players":["a","b"]}}`
+	if _, err := ReadReplay(strings.NewReader(header + "\n" + `{"tick":{"directions":["north","south"]}}`)); err != nil {
+		t.Fatalf("valid replay: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		replay string
+	}{
+		{"empty", ``},
+		{"missing header", `{"tick":{"directions":["north","north"]}}`},
+		{"duplicate header", header + "\n" + header},
+		{"one player", `{"header":{"width":20,"height":10,"ruleset":"classic","players":["a"]}}`},
+		{"invalid size", `{"header":{"width":0,"height":10,"ruleset":"classic","players":["a","b"]}}`},
+		{"spawn count", `{"header":{"width":20,"height":10,"ruleset":"classic","players":["a","b"],"spawns":[{"location":{"x":1,"y":1},"direction":"north"}]}}`},
+		{"spawn outside arena", `{"header":{"width":20,"height":10,"ruleset":"classic","players":["a","b"],"spawns":[{"location":{"x":1,"y":1},"direction":"north"},{"location":{"x":20,"y":1},"direction":"north"}]}}`},
+		{"invalid tick", header + "\n" + `{"tick":{"directions":["north"]}}`},
+		{"empty line", header + "\n{}"},
+		{"invalid JSON", header + "\n{"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if replay, err := ReadReplay(strings.NewReader(test.replay)); err == nil {
+				t.Errorf("got %+v, want an error", replay)
+			}
+		})
+	}
+}
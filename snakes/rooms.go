@@ -0,0 +1,71 @@
+package snakes
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Rooms is a collection of named Servers. Each room has its own
+// configuration, clients, viewers and game loop.
+//
+// The first room added is the default room.
+type Rooms struct {
+	mu          sync.Mutex
+	rooms       map[string]*Server
+	defaultRoom string
+}
+
+// NewRooms creates an empty room collection.
+func NewRooms() *Rooms {
+	return &Rooms{
+		rooms: make(map[string]*Server),
+	}
+}
+
+// Add adds the server to the collection under the given name.
+// An error is returned if the name is empty or is already in use.
+//
+// The caller is responsible for running the server.
+func (r *Rooms) Add(name string, s *Server) error {
+	if name == "" {
+		return errors.New("empty room name")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.rooms[name]; ok {
+		return errors.New("duplicate room name")
+	}
+	if len(r.rooms) == 0 {
+		r.defaultRoom = name
+	}
+	r.rooms[name] = s
+	return nil
+}
+
+// Get returns the server with the given name. If name is empty, the default
+// room is returned. nil is returned if the room does not exist.
+func (r *Rooms) Get(name string) *Server {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if name == "" {
+		name = r.defaultRoom
+	}
+	return r.rooms[name]
+}
+
+// Names returns the sorted names of all rooms.
+func (r *Rooms) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.rooms))
+	for name := range r.rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
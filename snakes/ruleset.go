@@ -0,0 +1,119 @@
+package snakes
+
+// Ruleset defines the rules that are used to advance a State from one tick
+// to the next.
+type Ruleset interface {
+	// Setup is called by NewState before the snakes and apple are placed.
+	// It may add rule specific items (e.g. walls) to the state.
+	Setup(s *State)
+
+	// Move returns the location that a snake head at from moves to when
+	// heading in the given direction. false is returned if the snake dies
+	// making the move (e.g. it collides with the edge of the arena).
+	Move(s *State, from Location, direction Direction) (Location, bool)
+}
+
+var (
+	_ Ruleset = ClassicRuleset{}
+	_ Ruleset = WraparoundRuleset{}
+	_ Ruleset = WallsRuleset{}
+)
+
+// RulesetByName returns the built-in rule set with the given name (classic,
+// wraparound or walls). false is returned if there is no such rule set.
+func RulesetByName(name string) (Ruleset, bool) {
+	switch name {
+	case "classic":
+		return ClassicRuleset{}, true
+	case "wraparound":
+		return WraparoundRuleset{}, true
+	case "walls":
+		return WallsRuleset{}, true
+	}
+	return nil, false
+}
+
+// RulesetName returns the name of the given built-in rule set. nil is treated
+// as ClassicRuleset. false is returned if r is not a built-in rule set.
+func RulesetName(r Ruleset) (string, bool) {
+	switch r.(type) {
+	case nil, ClassicRuleset:
+		return "classic", true
+	case WraparoundRuleset:
+		return "wraparound", true
+	case WallsRuleset:
+		return "walls", true
+	}
+	return "", false
+}
+
+// ClassicRuleset is the original rule set: a snake dies when it moves
+// outside of the arena.
+type ClassicRuleset struct{}
+
+// Setup implements Ruleset.
+func (ClassicRuleset) Setup(s *State) {}
+
+// Move implements Ruleset.
+func (ClassicRuleset) Move(s *State, from Location, direction Direction) (Location, bool) {
+	next := NextLocation(from, direction)
+	return next, next.IsInsideBounds(s.Width, s.Height)
+}
+
+// WraparoundRuleset is a toroidal rule set: a snake that moves off one edge
+// of the arena re-appears on the opposite edge.
+type WraparoundRuleset struct{}
+
+// Setup implements Ruleset.
+func (WraparoundRuleset) Setup(s *State) {}
+
+// Move implements Ruleset.
+func (WraparoundRuleset) Move(s *State, from Location, direction Direction) (Location, bool) {
+	next := NextLocation(from, direction)
+	next.X = (next.X%s.Width + s.Width) % s.Width
+	next.Y = (next.Y%s.Height + s.Height) % s.Height
+	return next, true
+}
+
+// WallsRuleset is the classic rule set with static wall obstacles inside of
+// the arena. A snake dies when it moves into a wall.
+type WallsRuleset struct {
+	// Wall locations. If nil, DefaultWalls is used.
+	Walls []Location
+}
+
+// Setup implements Ruleset.
+//
+// Walls that are outside of the arena are ignored.
+func (r WallsRuleset) Setup(s *State) {
+	walls := r.Walls
+	if walls == nil {
+		walls = DefaultWalls(s.Width, s.Height)
+	}
+
+	s.Walls = make([]Location, 0, len(walls))
+	for _, wall := range walls {
+		if wall.IsInsideBounds(s.Width, s.Height) {
+			s.Walls = append(s.Walls, wall)
+		}
+	}
+}
+
+// Move implements Ruleset.
+func (r WallsRuleset) Move(s *State, from Location, direction Direction) (Location, bool) {
+	next, ok := ClassicRuleset{}.Move(s, from, direction)
+	return next, ok && !s.IsWall(next)
+}
+
+// DefaultWalls returns a wall layout for an arena of the given size. The
+// layout consists of two horizontal bars, one a quarter of the way from the
+// top of the arena and one a quarter of the way from the bottom.
+func DefaultWalls(width, height int) []Location {
+	var walls []Location
+	for _, y := range []int{height / 4, height - 1 - height/4} {
+		for x := width / 4; x < width-width/4; x++ {
+			walls = append(walls, Location{X: x, Y: y})
+		}
+	}
+	return walls
+}
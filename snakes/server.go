@@ -1,7 +1,10 @@
 package snakes
 
 import (
+	"context"
 	"errors"
+	"io"
+	"log"
 	"math"
 	"math/rand"
 	"sync"
@@ -24,20 +27,58 @@ import (
 //
 // Once the round is over, the winner (or lack of winner) is broadcast, the server waits
 // ServerConfig.PostRoundWait, then the queue process is restarted.
+//
+// When the server is stopped, a ServerShutdownMessage is broadcast once the
+// round in progress is over, and the connections of the clients and viewers
+// are closed.
+//
+// Messages are sent to each client and viewer from its own bounded send
+// queue, so that a slow connection does not hold up the game. A client or
+// viewer whose SendMessage returns an error is disconnected (see
+// ServerConfig.SlowConsumer).
+//
+// The game loop can be controlled while it runs: clients can be kicked or
+// banned, rounds ended early, the loop paused, and the configuration changed
+// between rounds (see UpdateConfig).
 type Server struct {
-	config ServerConfig
+	// config is only changed by Run, with configMu held. Other goroutines
+	// must hold configMu to read it (see Config).
+	configMu      sync.Mutex
+	config        ServerConfig
+	pendingConfig *ServerConfig
 
 	broadcastMu sync.Mutex
 	viewers     []ViewerClient
 	lastMessage *Message
 
+	queuesMu sync.Mutex
+	queues   map[ViewerClient]*sendQueue
+
+	historyMu     sync.Mutex
+	history       []*roundRecord
+	historyActive bool
+	nextRoundID   int
+
 	clientsMu sync.Mutex
 	clients   []Client
+	sessions  map[string]*clientSession
+	banned    map[string]bool
+
+	controlMu     sync.Mutex
+	roundActive   bool
+	roundCommands chan roundCommand
+	// resumed is closed when the paused game loop is resumed. It is nil
+	// while the game loop is not paused.
+	resumed chan struct{}
 
 	isStopped uint32
 	stopped   chan struct{}
 
 	clientsUpdated chan struct{}
+
+	rng *rand.Rand
+	// Index of the next map in ServerConfig.Maps. Only used by Run.
+	nextMap int
 }
 
 // ServerConfig contains configuration variables for Server.
@@ -54,14 +95,71 @@ type ServerConfig struct {
 	// Duration of a round tick. This should be large enough for clients to
 	// receive the current state, process it, then send a response.
 	RoundTick time.Duration
+	// Rules used for each round. If nil, ClassicRuleset is used.
+	Ruleset Ruleset
+	// Strategy used to place the snakes at the start of each round. If nil,
+	// LineSpawn is used.
+	Spawn SpawnStrategy
+	// Number of arena cells per snake, used to size the arena from the
+	// number of players in the round (see ArenaSize). If unset,
+	// DefaultCellsPerSnake is used.
+	CellsPerSnake int
+	// Fixed arena dimensions, overriding the size computed from the number
+	// of players. The width is increased if it is less than the number of
+	// players.
+	Width, Height int
+	// Items kept in the arena in addition to the apple, e.g. extra apples
+	// or power-ups.
+	Items []ItemConfig
+	// Policy used to place the apple and items. If nil, UniformPlacement is
+	// used.
+	Placement PlacementPolicy
+	// Maps played in rotation, one per round. If set, the map's size, walls,
+	// spawn points and apple zones are used instead of the arena size and
+	// spawn strategy above.
+	Maps []*Map
+	// Directory in which a replay file is written for every round. If
+	// unset, rounds are not recorded.
+	RecordDir string
+	// Seed used to generate the seed of each round. If zero, the current
+	// time is used.
+	Seed int64
+	// If true, the next tick starts as soon as every alive snake's client
+	// has submitted a move for the current tick, instead of on a fixed
+	// interval. RoundTick is the maximum amount of time to wait for moves.
+	// Clients that do not implement MoveWaiter are always waited on for
+	// the full RoundTick.
+	Lockstep bool
+	// Amount of time that a disconnected client is kept, so that it can
+	// reconnect and resume control of its snake. If unset, disconnected
+	// clients are removed immediately.
+	SessionGrace time.Duration
+	// Maximum number of messages queued for each client and viewer. If
+	// unset, DefaultSendQueueSize is used.
+	SendQueueSize int
+	// What happens when the send queue of a client or viewer is full.
+	SlowConsumer SlowConsumerPolicy
+	// If true, the round in progress is ended without a winner when the
+	// server is stopped, instead of being played to the end. The
+	// RoundOverMessage is marked as aborted.
+	AbortRoundOnShutdown bool
 }
 
 // NewServer creates a new server with the given configuration.
 func NewServer(config ServerConfig) *Server {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
 	return &Server{
 		config:         config,
+		queues:         make(map[ViewerClient]*sendQueue),
 		clientsUpdated: make(chan struct{}, 1),
+		roundCommands:  make(chan roundCommand, 1),
 		stopped:        make(chan struct{}),
+
+		rng: rand.New(rand.NewSource(seed)),
 	}
 }
 
@@ -79,11 +177,17 @@ func (s *Server) clearClientsUpdated() {
 	}
 }
 
-// Stop requests that the server stop after the current round.
+// shutdownTimeout is the maximum amount of time that a stopping server waits
+// for its clients and viewers to be sent the messages in their send queues.
+const shutdownTimeout = time.Second * 5
+
+// Stop requests that the server stop after the current round (see
+// ServerConfig.AbortRoundOnShutdown). A paused game loop is resumed.
 func (s *Server) Stop() {
 	if atomic.CompareAndSwapUint32(&s.isStopped, 0, 1) {
 		close(s.stopped)
 	}
+	s.Resume()
 }
 
 // broadcast broadcasts msg too all of the server's viewers and the given clients.
@@ -92,20 +196,127 @@ func (s *Server) broadcast(msg *Message, clients ...Client) {
 	defer s.broadcastMu.Unlock()
 
 	s.lastMessage = msg
+	s.addHistory(msg)
+
+	s.queuesMu.Lock()
+	defer s.queuesMu.Unlock()
 
 	for _, viewer := range s.viewers {
-		viewer.SendMessage(msg)
+		s.queues[viewer].push(msg)
 	}
 
 	for _, client := range clients {
-		client.SendMessage(msg)
+		// Clients that have been removed during the round no longer have a
+		// queue
+		if q, ok := s.queues[client]; ok {
+			q.push(msg)
+		}
+	}
+}
+
+// send queues msg to be sent to the client or viewer.
+func (s *Server) send(c ViewerClient, msg *Message) {
+	s.queuesMu.Lock()
+	defer s.queuesMu.Unlock()
+
+	if q, ok := s.queues[c]; ok {
+		q.push(msg)
+	}
+}
+
+// startQueue creates the send queue of the client or viewer. onError is
+// called with every error that occurs sending a message.
+func (s *Server) startQueue(c ViewerClient, onError func(error)) {
+	s.queuesMu.Lock()
+	defer s.queuesMu.Unlock()
+
+	config := s.Config()
+	s.queues[c] = newSendQueue(c, config.SendQueueSize, config.SlowConsumer, onError)
+}
+
+// stopQueue stops the send queue of the client or viewer.
+func (s *Server) stopQueue(c ViewerClient) {
+	s.queuesMu.Lock()
+	defer s.queuesMu.Unlock()
+
+	if q, ok := s.queues[c]; ok {
+		q.close()
+		delete(s.queues, c)
+	}
+}
+
+// evictClient disconnects a client that could not be sent a message. The
+// client's connection is closed if it implements io.Closer. Clients that are
+// already disconnected are ignored.
+func (s *Server) evictClient(c Client, err error) {
+	s.clientsMu.Lock()
+	detached := s.isDetached(c)
+	generation := s.sessionGeneration(c)
+	s.clientsMu.Unlock()
+	if detached {
+		return
+	}
+
+	log.Printf("Evicting client %s: %s", c.ID(), err)
+	if closer, ok := c.(io.Closer); ok {
+		closer.Close()
+	}
+	s.DisconnectClient(c, generation)
+}
+
+// evictViewer removes a viewer that could not be sent a message. The
+// viewer's connection is closed if it implements io.Closer.
+func (s *Server) evictViewer(v ViewerClient, err error) {
+	log.Printf("Evicting viewer: %s", err)
+	if closer, ok := v.(io.Closer); ok {
+		closer.Close()
+	}
+	s.RemoveViewer(v)
+}
+
+// isStopping returns if Stop has been called.
+func (s *Server) isStopping() bool {
+	return atomic.LoadUint32(&s.isStopped) == 1
+}
+
+// sleep waits for d, or until the server is stopped.
+func (s *Server) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-s.stopped:
 	}
 }
 
 // Run runs the game loop.
-// The function returns after s.Stop is called.
-func (s *Server) Run() {
+// The function returns once the server has shut down, after s.Stop is called
+// or ctx is done.
+func (s *Server) Run(ctx context.Context) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-done:
+		}
+	}()
+	defer s.shutdown()
+
 	for {
+		s.applyConfig()
+
+		if resumed := s.pausedChan(); resumed != nil {
+			select {
+			case <-resumed:
+				continue
+			case <-s.stopped:
+				return
+			}
+		}
+
 		// Need at least two players connect to start the game
 		s.clientsMu.Lock()
 		s.clearClientsUpdated()
@@ -123,7 +334,7 @@ func (s *Server) Run() {
 			case <-s.clientsUpdated:
 				continue
 			case <-s.stopped:
-				break
+				return
 			}
 		}
 
@@ -137,7 +348,12 @@ func (s *Server) Run() {
 		select {
 		case <-time.After(s.config.PreRoundWait):
 		case <-s.stopped:
-			break
+			return
+		}
+
+		s.applyConfig()
+		if s.pausedChan() != nil {
+			continue
 		}
 
 		s.clientsMu.Lock()
@@ -146,38 +362,211 @@ func (s *Server) Run() {
 			s.clientsMu.Unlock()
 			continue
 		}
+		// Shuffle clients so no one is consistently starting from the same location
+		seed := s.rng.Int63()
 		roundClients := make([]Client, len(s.clients))
-		copy(roundClients, s.clients)
+		for i, idx := range SpawnOrder(seed, len(s.clients)) {
+			roundClients[i] = s.clients[idx]
+		}
 		s.clientsMu.Unlock()
 
-		// Shuffle clients so no one is consistently starting from the same location
-		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-		for i := 0; i < len(roundClients); i++ {
-			idx := rng.Intn(len(roundClients))
-			roundClients[i], roundClients[idx] = roundClients[idx], roundClients[i]
+		s.playRound(roundClients, seed)
+		if s.isStopping() {
+			return
 		}
+	}
+}
 
-		const size = 50 // TODO: base off of client count
+// shutdown broadcasts a ServerShutdownMessage to every client and viewer,
+// waits for their send queues to empty, then closes their connections.
+func (s *Server) shutdown() {
+	s.clientsMu.Lock()
+	clients := make([]Client, len(s.clients))
+	copy(clients, s.clients)
+	s.clientsMu.Unlock()
+
+	s.broadcast(&Message{
+		ServerShutdown: &ServerShutdownMessage{},
+	}, clients...)
+
+	s.queuesMu.Lock()
+	queues := make(map[ViewerClient]*sendQueue, len(s.queues))
+	for c, q := range s.queues {
+		queues[c] = q
+		q.drain()
+	}
+	s.queuesMu.Unlock()
 
-		cfg := StateConfig{
-			Width:              size,
-			Height:             size / 2,
-			SnakeCount:         len(roundClients),
-			InitialSnakeLength: 5,
+	timeout := time.NewTimer(shutdownTimeout)
+	defer timeout.Stop()
+	for c, q := range queues {
+		select {
+		case <-q.done:
+		case <-timeout.C:
+		}
+		q.close()
+		if closer, ok := c.(io.Closer); ok {
+			closer.Close()
 		}
-		gameState := NewState(cfg)
+	}
+}
 
-		var roundEndTime time.Time
-		var roundLimitTimer *time.Timer
-		if s.config.RoundDuration > 0 {
-			roundEndTime = time.Now().Add(s.config.RoundDuration)
-			roundLimitTimer = time.NewTimer(s.config.RoundDuration)
+// playRound plays a single round with the given clients. It returns once the
+// round is over and ServerConfig.PostRoundWait has elapsed.
+func (s *Server) playRound(roundClients []Client, seed int64) {
+	width, height := ArenaSize(len(roundClients), s.config.CellsPerSnake)
+	if s.config.Width > 0 {
+		width = s.config.Width
+		if width < len(roundClients) {
+			width = len(roundClients)
+		}
+	}
+	if s.config.Height > 0 {
+		height = s.config.Height
+	}
+
+	names := make([]string, len(roundClients))
+	for i, client := range roundClients {
+		names[i] = client.ID()
+	}
+
+	cfg := StateConfig{
+		Width:              width,
+		Height:             height,
+		SnakeCount:         len(roundClients),
+		InitialSnakeLength: 5,
+		Ruleset:            s.config.Ruleset,
+		Spawn:              s.config.Spawn,
+		Items:              s.config.Items,
+		Placement:          s.config.Placement,
+		Seed:               seed,
+	}
+	if len(s.config.Maps) > 0 {
+		s.config.Maps[s.nextMap%len(s.config.Maps)].Apply(&cfg)
+		s.nextMap++
+	}
+	gameState, err := NewState(cfg)
+	if err != nil {
+		log.Printf("Skipping round: %s", err)
+		return
+	}
+
+	// Snakes move in the direction they spawned facing until their client
+	// chooses one, even if the client has not received the round state yet
+	for i, client := range roundClients {
+		if resetter, ok := client.(DirectionResetter); ok {
+			resetter.ResetDirection(gameState.Snakes[i].Direction)
+		}
+	}
+
+	recorder := s.startRecording(cfg, gameState, names)
+	defer recorder.close()
+
+	s.startHistory()
+
+	s.setRoundActive(true)
+	defer s.setRoundActive(false)
+
+	var roundEndTime time.Time
+	var roundLimitTimer *time.Timer
+	if s.config.RoundDuration > 0 {
+		roundEndTime = time.Now().Add(s.config.RoundDuration)
+		roundLimitTimer = time.NewTimer(s.config.RoundDuration)
+	} else {
+		roundLimitTimer = time.NewTimer(math.MaxInt64)
+	}
+	defer roundLimitTimer.Stop()
+
+	msg := &Message{
+		RoundStateMessage: roundStateMessageFromState(names, gameState),
+	}
+	if !roundEndTime.IsZero() {
+		msg.RoundStateMessage.SecondsRemaining = new(int)
+		*msg.RoundStateMessage.SecondsRemaining = int(roundEndTime.Sub(time.Now())/time.Second) + 1
+	}
+	s.broadcast(msg, roundClients...)
+
+	ticker := time.NewTicker(s.config.RoundTick)
+	defer ticker.Stop()
+
+	var abort <-chan struct{}
+	if s.config.AbortRoundOnShutdown {
+		abort = s.stopped
+	}
+
+	paused := false
+	for {
+		nextTick := ticker.C
+		resumed := s.pausedChan()
+		if resumed != nil {
+			// Stop the ticker, and discard a tick from before the pause,
+			// so that the next tick is a full tick after the round is
+			// resumed
+			if !paused {
+				ticker.Stop()
+				select {
+				case <-ticker.C:
+				default:
+				}
+				paused = true
+			}
+			nextTick = nil
 		} else {
-			roundLimitTimer = time.NewTimer(math.MaxInt64)
+			if paused {
+				ticker.Reset(s.config.RoundTick)
+				paused = false
+			}
+			if s.config.Lockstep {
+				nextTick = s.lockstepTick(gameState, roundClients)
+			}
+		}
+
+		var ended, restarted, aborted bool
+		select {
+		case <-nextTick:
+		case <-resumed:
+			continue
+		case <-roundLimitTimer.C:
+			ended = true
+		case cmd := <-s.roundCommands:
+			ended, restarted = true, cmd == restartRound
+			aborted = restarted
+		case <-abort:
+			ended, aborted = true, true
+		}
+
+		if ended {
+			rom := &RoundOverMessage{
+				Aborted: aborted,
+			}
+			if winner, ok := gameState.LongestSnake(); ok && !aborted {
+				rom.Winner = new(string)
+				*rom.Winner = names[winner]
+			}
+			s.broadcast(&Message{
+				RoundOverMessage: rom,
+			}, roundClients...)
+			if restarted {
+				recorder.discard()
+			} else {
+				recorder.writeRoundOver(rom)
+			}
+			s.setRoundActive(false)
+			if !aborted {
+				s.sleep(s.config.PostRoundWait)
+			}
+			return
 		}
 
+		directions := make([]Direction, len(roundClients))
+		for i, client := range roundClients {
+			directions[i] = client.Direction()
+		}
+		recorder.writeTick(directions)
+
+		gameState = gameState.Next(directions)
 		msg := &Message{
-			RoundStateMessage: roundStateMessageFromState(roundClients, gameState),
+			RoundStateMessage: roundStateMessageFromState(names, gameState),
 		}
 		if !roundEndTime.IsZero() {
 			msg.RoundStateMessage.SecondsRemaining = new(int)
@@ -185,57 +574,54 @@ func (s *Server) Run() {
 		}
 		s.broadcast(msg, roundClients...)
 
-		directions := make([]Direction, len(roundClients))
-		ticker := time.NewTicker(s.config.RoundTick)
-
-	gameLoop:
-		for {
-			select {
-			case <-ticker.C:
-			case <-roundLimitTimer.C:
-				rom := &RoundOverMessage{}
-				if winner, ok := gameState.LongestSnake(); ok {
-					rom.Winner = new(string)
-					*rom.Winner = roundClients[winner].ID()
-				}
-				s.broadcast(&Message{
-					RoundOverMessage: rom,
-				}, roundClients...)
-				roundLimitTimer.Stop()
-				time.Sleep(s.config.PostRoundWait)
-				break gameLoop
-			}
-
-			for i, client := range roundClients {
-				directions[i] = client.Direction()
+		if completed, winner := gameState.IsCompleted(); completed {
+			rom := &RoundOverMessage{}
+			if winner >= 0 {
+				rom.Winner = new(string)
+				*rom.Winner = names[winner]
 			}
+			s.broadcast(&Message{
+				RoundOverMessage: rom,
+			}, roundClients...)
+			recorder.writeRoundOver(rom)
+			s.setRoundActive(false)
+			s.sleep(s.config.PostRoundWait)
+			return
+		}
+	}
+}
 
-			gameState = gameState.Next(directions)
-			msg := &Message{
-				RoundStateMessage: roundStateMessageFromState(roundClients, gameState),
+// lockstepTick returns a channel that is closed once every alive snake's
+// client has submitted its move for the state's tick, or once RoundTick has
+// elapsed.
+func (s *Server) lockstepTick(state *State, clients []Client) <-chan time.Time {
+	done := make(chan time.Time, 1)
+	roundTick := s.config.RoundTick
+
+	go func() {
+		timeout := time.NewTimer(roundTick)
+		defer timeout.Stop()
+		defer func() {
+			done <- time.Now()
+		}()
+
+		for i, client := range clients {
+			if !state.Snakes[i].Alive {
+				continue
 			}
-			if !roundEndTime.IsZero() {
-				msg.RoundStateMessage.SecondsRemaining = new(int)
-				*msg.RoundStateMessage.SecondsRemaining = int(roundEndTime.Sub(time.Now())/time.Second) + 1
+			var moved <-chan struct{}
+			if waiter, ok := client.(MoveWaiter); ok {
+				moved = waiter.Moved(state.Tick)
 			}
-			s.broadcast(msg, roundClients...)
-
-			if completed, winner := gameState.IsCompleted(); completed {
-				rom := &RoundOverMessage{}
-				if winner >= 0 {
-					rom.Winner = new(string)
-					*rom.Winner = roundClients[winner].ID()
-				}
-				s.broadcast(&Message{
-					RoundOverMessage: rom,
-				}, roundClients...)
-				roundLimitTimer.Stop()
-				time.Sleep(s.config.PostRoundWait)
-				break
+			select {
+			case <-moved:
+			case <-timeout.C:
+				return
 			}
 		}
-		ticker.Stop()
-	}
+	}()
+
+	return done
 }
 
 // AddClient adds the client to the server.
@@ -245,20 +631,29 @@ func (s *Server) AddClient(c Client) error {
 	defer s.clientsMu.Unlock()
 
 	id := c.ID()
+	if s.banned[id] {
+		return ErrBannedClient
+	}
 	for _, client := range s.clients {
 		if client.ID() == id {
 			return errors.New("duplicate client ID")
 		}
 	}
 
+	s.startQueue(c, func(err error) {
+		s.evictClient(c, err)
+	})
+
 	// send message in case we're in the middle of a round
-	c.SendMessage(&Message{
+	s.send(c, &Message{
 		WaitingMessage: &WaitingMessage{
 			CurrentPlayers:  1,
-			RequiredPlayers: s.config.MinimumClients,
+			RequiredPlayers: s.Config().MinimumClients,
 		},
 	})
 
+	s.startSession(c)
+
 	s.clients = append(s.clients, c)
 	s.signalClientsUpdated()
 	return nil
@@ -269,11 +664,16 @@ func (s *Server) AddClient(c Client) error {
 func (s *Server) RemoveClient(c Client) bool {
 	s.clientsMu.Lock()
 	defer s.clientsMu.Unlock()
+	return s.removeClient(c)
+}
 
-	id := c.ID()
+// removeClient removes the client from the server. s.clientsMu must be held.
+func (s *Server) removeClient(c Client) bool {
+	s.endSession(c)
+	s.stopQueue(c)
 
 	for i, client := range s.clients {
-		if client.ID() == id {
+		if client == c {
 			s.clients = append(s.clients[:i], s.clients[i+1:]...)
 			s.signalClientsUpdated()
 			return true
@@ -287,8 +687,13 @@ func (s *Server) AddViewer(v ViewerClient) error {
 	s.broadcastMu.Lock()
 	defer s.broadcastMu.Unlock()
 
+	s.startQueue(v, func(err error) {
+		s.evictViewer(v, err)
+	})
 	s.viewers = append(s.viewers, v)
-	v.SendMessage(s.lastMessage)
+	if s.lastMessage != nil {
+		s.send(v, s.lastMessage)
+	}
 	return nil
 }
 
@@ -300,6 +705,7 @@ func (s *Server) RemoveViewer(v ViewerClient) error {
 	for i, viewer := range s.viewers {
 		if v == viewer {
 			s.viewers = append(s.viewers[:i], s.viewers[i+1:]...)
+			s.stopQueue(v)
 			break
 		}
 	}
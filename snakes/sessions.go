@@ -0,0 +1,161 @@
+package snakes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// clientSession is the session of a client added to a Server. A session
+// allows a client that loses its connection to resume control of its snake.
+type clientSession struct {
+	client Client
+
+	// detached is true while the client is disconnected.
+	detached bool
+	// generation is incremented every time the client disconnects or is
+	// resumed, so that expiry timers and disconnects of connections that
+	// have since been replaced are ignored.
+	generation int
+	timer      *time.Timer
+}
+
+// newSessionToken generates a random session token.
+func newSessionToken() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// startSession creates a session for the client and sends it the session
+// token. s.clientsMu must be held.
+func (s *Server) startSession(c Client) {
+	if s.sessions == nil {
+		s.sessions = make(map[string]*clientSession)
+	}
+
+	token := newSessionToken()
+	s.sessions[token] = &clientSession{
+		client: c,
+	}
+	s.send(c, &Message{
+		SessionMessage: &SessionMessage{
+			Token: token,
+		},
+	})
+}
+
+// endSession removes the client's session. s.clientsMu must be held.
+func (s *Server) endSession(c Client) {
+	for token, session := range s.sessions {
+		if session.client == c {
+			if session.timer != nil {
+				session.timer.Stop()
+			}
+			delete(s.sessions, token)
+			return
+		}
+	}
+}
+
+// isDetached returns if the client is disconnected and waiting to be
+// resumed. s.clientsMu must be held.
+func (s *Server) isDetached(c Client) bool {
+	for _, session := range s.sessions {
+		if session.client == c {
+			return session.detached
+		}
+	}
+	return false
+}
+
+// sessionGeneration returns the generation of the client's session. s.clientsMu
+// must be held.
+func (s *Server) sessionGeneration(c Client) int {
+	for _, session := range s.sessions {
+		if session.client == c {
+			return session.generation
+		}
+	}
+	return 0
+}
+
+// DisconnectClient is called when the client's connection has been lost.
+// generation identifies the connection: it is zero for the connection that
+// the client was added with, and the value returned by ResumeClient for a
+// resumed connection. The call is ignored if the client has been resumed or
+// disconnected since, so that a connection that has been replaced can not
+// disconnect the client's new connection.
+//
+// The client is kept in the server for ServerConfig.SessionGrace, during
+// which it can be resumed with ResumeClient. It is removed from the server
+// once the grace period ends. If there is no grace period, the client is
+// removed immediately.
+func (s *Server) DisconnectClient(c Client, generation int) {
+	grace := s.Config().SessionGrace
+
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	for token, session := range s.sessions {
+		if session.client == c {
+			if session.generation != generation {
+				return
+			}
+			if grace <= 0 {
+				s.removeClient(c)
+				return
+			}
+			token := token
+			session.detached = true
+			session.generation++
+			generation := session.generation
+			session.timer = time.AfterFunc(grace, func() {
+				s.expireSession(token, generation)
+			})
+			return
+		}
+	}
+
+	s.removeClient(c)
+}
+
+// expireSession removes the client of a session whose grace period has ended.
+func (s *Server) expireSession(token string, generation int) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok || !session.detached || session.generation != generation {
+		return
+	}
+	s.removeClient(session.client)
+}
+
+// ResumeClient returns the client with the given session token, and cancels
+// its removal from the server if it is disconnected. The returned generation
+// identifies the new connection, and must be passed to DisconnectClient once
+// it is lost. false is returned if there is no client with the session token.
+//
+// The session can be resumed before the server has noticed that the client's
+// previous connection was lost, in which case the new connection takes over
+// from it. The caller is responsible for re-attaching the client to its new
+// connection and closing the previous one.
+func (s *Server) ResumeClient(token string) (c Client, generation int, ok bool) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, 0, false
+	}
+	if session.detached {
+		session.detached = false
+		session.timer.Stop()
+		session.timer = nil
+	}
+	session.generation++
+	return session.client, session.generation, true
+}
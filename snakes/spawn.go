@@ -0,0 +1,313 @@
+package snakes
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+)
+
+// ErrNoSpawnLocation is returned by the built-in spawn strategies, and by
+// NewState, when there are more snakes than free cells in the arena.
+var ErrNoSpawnLocation = errors.New("no free spawn location")
+
+// Spawn is the starting location of a snake, and the direction that it is
+// initially facing.
+type Spawn struct {
+	Location  Location  `json:"location"`
+	Direction Direction `json:"direction"`
+}
+
+// SpawnStrategy decides where snakes start in the arena.
+type SpawnStrategy interface {
+	// Spawn returns the spawns of n snakes. It is called by NewState after
+	// the rule set's Setup, so the state's walls are in place, but before
+	// any snakes are placed.
+	//
+	// Every random decision must be made with rng, so that states created
+	// with the same seed are identical. An error is returned if the snakes
+	// can not be placed.
+	Spawn(s *State, n int, rng *rand.Rand) ([]Spawn, error)
+}
+
+var (
+	_ SpawnStrategy = LineSpawn{}
+	_ SpawnStrategy = CircleSpawn{}
+	_ SpawnStrategy = RandomSpawn{}
+	_ SpawnStrategy = CornerSpawn{}
+	_ SpawnStrategy = PositionSpawn{}
+)
+
+// SpawnByName returns the built-in spawn strategy with the given name (line,
+// circle, random or corners). false is returned if there is no such
+// strategy.
+func SpawnByName(name string) (SpawnStrategy, bool) {
+	switch name {
+	case "line":
+		return LineSpawn{}, true
+	case "circle":
+		return CircleSpawn{}, true
+	case "random":
+		return RandomSpawn{}, true
+	case "corners":
+		return CornerSpawn{}, true
+	}
+	return nil, false
+}
+
+// LineSpawn places the snakes evenly spaced on a horizontal line through
+// the middle of the arena.
+type LineSpawn struct{}
+
+// Spawn implements SpawnStrategy.
+func (LineSpawn) Spawn(s *State, n int, rng *rand.Rand) ([]Spawn, error) {
+	locations := make([]Location, n)
+	for i := range locations {
+		locations[i] = Location{
+			X: s.Width*i/n + s.Width/n/2,
+			Y: s.Height / 2,
+		}
+	}
+	return facingSpawns(s, nil, locations)
+}
+
+// CircleSpawn places the snakes evenly spaced on an ellipse around the
+// center of the arena.
+type CircleSpawn struct{}
+
+// Spawn implements SpawnStrategy.
+func (CircleSpawn) Spawn(s *State, n int, rng *rand.Rand) ([]Spawn, error) {
+	locations := make([]Location, n)
+	for i := range locations {
+		angle := 2 * math.Pi * float64(i) / float64(n)
+		locations[i] = Location{
+			X: int(float64(s.Width)/2 + float64(s.Width)/3*math.Cos(angle)),
+			Y: int(float64(s.Height)/2 + float64(s.Height)/3*math.Sin(angle)),
+		}
+	}
+	return facingSpawns(s, nil, locations)
+}
+
+// RandomSpawn places the snakes at random locations.
+type RandomSpawn struct {
+	// Minimum Manhattan distance between snakes. If unset, a separation
+	// based on the arena size and number of snakes is used. The separation
+	// is reduced if the snakes do not fit.
+	MinSeparation int
+}
+
+// Spawn implements SpawnStrategy.
+func (r RandomSpawn) Spawn(s *State, n int, rng *rand.Rand) ([]Spawn, error) {
+	return r.spawnAround(s, nil, n, rng)
+}
+
+// spawnAround places n snakes at random locations, keeping them apart from
+// each other and from the fixed spawn locations.
+func (r RandomSpawn) spawnAround(s *State, fixed []Location, n int, rng *rand.Rand) ([]Spawn, error) {
+	separation := r.MinSeparation
+	if separation <= 0 {
+		separation = int(math.Sqrt(float64(s.Width*s.Height) / float64(n)))
+	}
+
+	const attempts = 100
+	locations := make([]Location, 0, n)
+	for len(locations) < n {
+		placed := false
+		for attempt := 0; attempt < attempts && !placed; attempt++ {
+			l := Location{
+				X: rng.Intn(s.Width),
+				Y: rng.Intn(s.Height),
+			}
+			if s.IsWall(l) {
+				continue
+			}
+			placed = true
+			for _, other := range append(fixed[:len(fixed):len(fixed)], locations...) {
+				if distance(l, other) < separation {
+					placed = false
+					break
+				}
+			}
+			if placed {
+				locations = append(locations, l)
+			}
+		}
+		if !placed {
+			if separation <= 1 {
+				// Let facingSpawns find free locations for the rest
+				for len(locations) < n {
+					locations = append(locations, Location{})
+				}
+				break
+			}
+			separation--
+		}
+	}
+	return facingSpawns(s, fixed, locations)
+}
+
+// CornerSpawn places the first four snakes in the corners of the arena, set
+// in from the edges. Any other snakes are placed evenly spaced between the
+// corners.
+type CornerSpawn struct{}
+
+// Spawn implements SpawnStrategy.
+func (CornerSpawn) Spawn(s *State, n int, rng *rand.Rand) ([]Spawn, error) {
+	left, top := s.Width/6, s.Height/6
+	right, bottom := s.Width-1-left, s.Height-1-top
+	corners := []Location{
+		{X: left, Y: top},
+		{X: right, Y: bottom},
+		{X: right, Y: top},
+		{X: left, Y: bottom},
+	}
+
+	locations := make([]Location, 0, n)
+	for i := 0; i < n && i < len(corners); i++ {
+		locations = append(locations, corners[i])
+	}
+
+	// Walk around the rectangle between the corners, placing the remaining
+	// snakes evenly
+	if rest := n - len(locations); rest > 0 {
+		width, height := right-left, bottom-top
+		perimeter := 2 * (width + height)
+		for i := 0; i < rest; i++ {
+			p := perimeter*i/rest + perimeter/rest/2
+			var l Location
+			switch {
+			case p < width:
+				l = Location{X: left + p, Y: top}
+			case p < width+height:
+				l = Location{X: right, Y: top + p - width}
+			case p < 2*width+height:
+				l = Location{X: right - (p - width - height), Y: bottom}
+			default:
+				l = Location{X: left, Y: bottom - (p - 2*width - height)}
+			}
+			locations = append(locations, l)
+		}
+	}
+	return facingSpawns(s, nil, locations)
+}
+
+// PositionSpawn places the snakes at fixed spawns, in order. If there are
+// more snakes than spawns, the remaining snakes are placed with RandomSpawn,
+// away from the fixed spawns.
+type PositionSpawn struct {
+	Spawns []Spawn
+}
+
+// Spawn implements SpawnStrategy.
+func (p PositionSpawn) Spawn(s *State, n int, rng *rand.Rand) ([]Spawn, error) {
+	spawns := make([]Spawn, 0, n)
+	fixed := make([]Location, 0, n)
+	for i := 0; i < n && i < len(p.Spawns); i++ {
+		spawns = append(spawns, p.Spawns[i])
+		fixed = append(fixed, p.Spawns[i].Location)
+	}
+	if len(spawns) < n {
+		rest, err := RandomSpawn{}.spawnAround(s, fixed, n-len(spawns), rng)
+		if err != nil {
+			return nil, err
+		}
+		spawns = append(spawns, rest...)
+	}
+	return spawns, nil
+}
+
+// facingSpawns returns spawns at the given locations, facing open space away
+// from the other snakes, including those at the fixed locations (see
+// openDirection). Locations that are outside of the arena, on a wall, fixed
+// or already taken are moved to the nearest free location.
+// ErrNoSpawnLocation is returned if there are not enough free locations.
+func facingSpawns(s *State, fixed, locations []Location) ([]Spawn, error) {
+	taken := make(map[Location]bool, len(fixed)+len(locations))
+	for _, l := range fixed {
+		taken[l] = true
+	}
+	for i, l := range locations {
+		l, ok := nearestFreeLocation(s, l, taken)
+		if !ok {
+			return nil, ErrNoSpawnLocation
+		}
+		taken[l] = true
+		locations[i] = l
+	}
+
+	all := append(fixed[:len(fixed):len(fixed)], locations...)
+	spawns := make([]Spawn, len(locations))
+	for i, l := range locations {
+		spawns[i] = Spawn{
+			Location:  l,
+			Direction: openDirection(s, l, all, taken),
+		}
+	}
+	return spawns, nil
+}
+
+// nearestFreeLocation returns the location closest to l that is inside of
+// the arena, is not a wall and is not taken. false is returned if there is
+// no free location in the arena.
+func nearestFreeLocation(s *State, l Location, taken map[Location]bool) (Location, bool) {
+	if l.X < 0 {
+		l.X = 0
+	} else if l.X >= s.Width {
+		l.X = s.Width - 1
+	}
+	if l.Y < 0 {
+		l.Y = 0
+	} else if l.Y >= s.Height {
+		l.Y = s.Height - 1
+	}
+
+	seen := map[Location]bool{l: true}
+	queue := []Location{l}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if !s.IsWall(current) && !taken[current] {
+			return current, true
+		}
+		for _, d := range allDirections {
+			next := NextLocation(current, d)
+			if next.IsInsideBounds(s.Width, s.Height) && !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return Location{}, false
+}
+
+// spawnClearance is the number of free cells that a snake should have in
+// front of it when it spawns.
+const spawnClearance = 5
+
+// openDirection returns the direction that a snake spawning at l should face:
+// one with at least spawnClearance free cells in front of it (or as many as
+// possible), leading away from the other spawn locations.
+func openDirection(s *State, l Location, locations []Location, taken map[Location]bool) Direction {
+	best, bestRun, bestCrowding := DirectionNorth, -1, math.Inf(1)
+	for _, d := range allDirections {
+		run, end := 0, l
+		for next := NextLocation(l, d); run < spawnClearance; next = NextLocation(next, d) {
+			if !next.IsInsideBounds(s.Width, s.Height) || s.IsWall(next) || taken[next] {
+				break
+			}
+			run, end = run+1, next
+		}
+
+		// How close the snake is to the other snakes once it has moved
+		crowding := 0.0
+		for _, other := range locations {
+			if other != l {
+				crowding += 1 / float64(distance(end, other)+1)
+			}
+		}
+
+		if run > bestRun || (run == bestRun && crowding < bestCrowding) {
+			best, bestRun, bestCrowding = d, run, crowding
+		}
+	}
+	return best
+}
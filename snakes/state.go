@@ -2,9 +2,8 @@ package snakes
 
 import (
 	"encoding"
-	"encoding/binary"
 	"errors"
-	"hash/crc64"
+	"math"
 	"math/rand"
 )
 
@@ -98,6 +97,12 @@ type Snake struct {
 	Alive  bool
 	Length int
 	Pieces []Location // Pieces[0] is the head of the snake
+	// Direction that the snake is facing: the direction of its last move,
+	// or the direction it faced when it spawned.
+	Direction Direction
+	// Number of ticks that the snake can move through snake bodies for
+	// (see ItemPhase).
+	Phase int
 }
 
 // IsAt returns if the snake has a piece at the given location.
@@ -125,6 +130,24 @@ type StateConfig struct {
 	Width, Height      int
 	SnakeCount         int
 	InitialSnakeLength int
+	// Rules used to advance the state. If nil, ClassicRuleset is used.
+	Ruleset Ruleset
+	// Strategy used to place the snakes. If nil, LineSpawn is used.
+	Spawn SpawnStrategy
+	// Walls added to the arena, in addition to those of the rule set.
+	Walls []Location
+	// Locations in which the apple can be placed. If empty, the apple can
+	// be placed anywhere in the arena.
+	AppleZones []Location
+	// Items kept in the arena in addition to the apple.
+	Items []ItemConfig
+	// Policy used to place the apple and items. If nil, UniformPlacement is
+	// used.
+	Placement PlacementPolicy
+	// Seed for every random decision made by the state. Two states created
+	// with the same configuration and advanced with the same directions
+	// are identical.
+	Seed int64
 }
 
 // State represents a 2D game area with two or more snakes and a single apple.
@@ -132,12 +155,25 @@ type State struct {
 	Width, Height int
 	Snakes        []*Snake
 	Apple         Apple
+	Walls         []Location
+	AppleZones    []Location
+	Items         []Item
+	ItemConfigs   []ItemConfig
+	Ruleset       Ruleset
+	Placement     PlacementPolicy
+	Seed          int64
+	// Number of times Next has been called since the initial state.
+	Tick int
+	// Set when there was no free location left for the apple. The game is
+	// completed once the arena is full.
+	Full bool
 }
 
 // NewState returns a new state based on the given initial configuration.
 // The function panics if the snake count is less than 2.
-// The function panics if the snake count is greater than the width.
-func NewState(cfg StateConfig) *State {
+// An error is returned if the snakes can not be spawned, such as when there
+// is not enough space in the arena for every snake (ErrNoSpawnLocation).
+func NewState(cfg StateConfig) (*State, error) {
 	if cfg.SnakeCount < 2 {
 		panic("snakeCount < 2")
 	}
@@ -146,72 +182,118 @@ func NewState(cfg StateConfig) *State {
 		Width:  cfg.Width,
 		Height: cfg.Height,
 
-		Snakes: make([]*Snake, cfg.SnakeCount),
+		AppleZones:  cfg.AppleZones,
+		ItemConfigs: cfg.Items,
+		Ruleset:     cfg.Ruleset,
+		Placement:   cfg.Placement,
+		Seed:        cfg.Seed,
 	}
 
-	if cfg.SnakeCount > s.Width {
-		panic("snakeCount > s.Width")
+	s.ruleset().Setup(s)
+	for _, wall := range cfg.Walls {
+		if wall.IsInsideBounds(s.Width, s.Height) {
+			s.Walls = append(s.Walls, wall)
+		}
 	}
 
-	xSpaceBetween := s.Width / cfg.SnakeCount
-	xInitialSpace := xSpaceBetween / 2
-
-	yLine := s.Height / 2
+	spawn := cfg.Spawn
+	if spawn == nil {
+		spawn = LineSpawn{}
+	}
+	spawns, err := spawn.Spawn(s, cfg.SnakeCount, rand.New(rand.NewSource(s.Seed)))
+	if err != nil {
+		return nil, err
+	}
 
+	s.Snakes = make([]*Snake, cfg.SnakeCount)
 	for i := range s.Snakes {
 		s.Snakes[i] = &Snake{
-			Alive:  true,
-			Length: cfg.InitialSnakeLength,
+			Alive:     true,
+			Length:    cfg.InitialSnakeLength,
+			Direction: spawns[i].Direction,
 		}
 		s.Snakes[i].Pieces = make([]Location, 1, s.Snakes[i].Length)
-		s.Snakes[i].Pieces[0] = Location{
-			X: xInitialSpace + xSpaceBetween*i,
-			Y: yLine,
-		}
+		s.Snakes[i].Pieces[0] = spawns[i].Location
 	}
 
+	location, ok := s.place(s.Seed, s.Walls)
 	s.Apple = Apple{
-		Location: GenerateAppleLocation(s.Width, s.Height, s.Snakes),
+		Location: location,
 	}
+	s.Full = !ok
+	s.refillItems()
 
-	return s
+	return s, nil
 }
 
-// GenerateAppleLocation calculates the location for the apple on the game board.
-func GenerateAppleLocation(width, height int, snakes []*Snake) Location {
-	h := crc64.New(crc64.MakeTable(crc64.ISO))
+// DefaultCellsPerSnake is the default number of arena cells per snake used by
+// ArenaSize.
+const DefaultCellsPerSnake = 150
 
-	for _, snake := range snakes {
-		var b [16]byte
-		var x, y uint64
-		if snake.Alive {
-			x, y = uint64(snake.Pieces[0].X), uint64(snake.Pieces[0].Y)
-		}
-		binary.BigEndian.PutUint64(b[:8], x)
-		binary.BigEndian.PutUint64(b[8:], y)
-		h.Write(b[:])
+// Minimum arena dimensions returned by ArenaSize.
+const (
+	minArenaWidth  = 20
+	minArenaHeight = 10
+)
+
+// ArenaSize returns the dimensions of an arena for the given number of
+// snakes, so that there are roughly cellsPerSnake cells per snake. The arena
+// is twice as wide as it is high, and is at least one cell wide per snake.
+//
+// If cellsPerSnake is not positive, DefaultCellsPerSnake is used.
+func ArenaSize(snakes, cellsPerSnake int) (width, height int) {
+	if cellsPerSnake <= 0 {
+		cellsPerSnake = DefaultCellsPerSnake
 	}
 
-	rng := rand.New(rand.NewSource(int64(h.Sum64())))
-	for {
-		x := rng.Intn(width)
-		y := rng.Intn(height)
+	height = int(math.Ceil(math.Sqrt(float64(snakes*cellsPerSnake) / 2)))
+	if height < minArenaHeight {
+		height = minArenaHeight
+	}
+	width = height * 2
+	if width < minArenaWidth {
+		width = minArenaWidth
+	}
+	if width < snakes {
+		width = snakes
+	}
+	return width, height
+}
 
-		ok := true
-		for _, snake := range snakes {
-			if snake.IsAt(Location{x, y}) {
-				ok = false
-				break
-			}
-		}
+// SpawnOrder returns the starting order of n players for the given seed.
+// Element i is the index of the player that takes snake number i.
+func SpawnOrder(seed int64, n int) []int {
+	return rand.New(rand.NewSource(seed)).Perm(n)
+}
 
-		if ok {
-			return Location{
-				X: x,
-				Y: y,
-			}
+// ruleset returns the state's rule set, or ClassicRuleset if one is not set.
+func (s *State) ruleset() Ruleset {
+	if s.Ruleset == nil {
+		return ClassicRuleset{}
+	}
+	return s.Ruleset
+}
+
+// IsWall returns if there is a wall at the given location.
+func (s *State) IsWall(l Location) bool {
+	for _, wall := range s.Walls {
+		if wall == l {
+			return true
 		}
 	}
+	return false
+}
+
+// GenerateAppleLocation calculates the location for the apple on the game
+// board, picking any location that is not occupied by a snake. false is
+// returned if there is no free location.
+func GenerateAppleLocation(width, height int, snakes []*Snake) (Location, bool) {
+	s := &State{
+		Width:  width,
+		Height: height,
+		Snakes: snakes,
+	}
+	return s.place(0, nil)
 }
 
 // clone returns a deep clone of the state.
@@ -223,14 +305,25 @@ func (s *State) clone() (newState *State, maxLength int) {
 
 		Snakes: make([]*Snake, len(s.Snakes)),
 
-		Apple: s.Apple,
+		Apple:       s.Apple,
+		Walls:       s.Walls,
+		AppleZones:  s.AppleZones,
+		Items:       s.Items,
+		ItemConfigs: s.ItemConfigs,
+		Ruleset:     s.Ruleset,
+		Placement:   s.Placement,
+		Seed:        s.Seed,
+		Tick:        s.Tick,
+		Full:        s.Full,
 	}
 
 	for i, snake := range s.Snakes {
 		newState.Snakes[i] = &Snake{
-			Alive:  snake.Alive,
-			Length: snake.Length,
-			Pieces: make([]Location, len(snake.Pieces), cap(snake.Pieces)),
+			Alive:     snake.Alive,
+			Length:    snake.Length,
+			Pieces:    make([]Location, len(snake.Pieces), cap(snake.Pieces)),
+			Direction: snake.Direction,
+			Phase:     snake.Phase,
 		}
 		if snake.Length > maxLength {
 			maxLength = snake.Length
@@ -252,23 +345,38 @@ func (s *State) Next(snakeDirections []Direction) *State {
 	}
 
 	next, maxLength := s.clone()
+	next.Tick++
+	rules := next.ruleset()
 
 	tails := make(map[Location]int, len(next.Snakes)*maxLength)
 	headLocations := make(map[locationPair]int, len(next.Snakes))
 	nextHeadLocations := make(map[Location]int, len(next.Snakes))
 	repositionApple := false
+	eaten := make(map[int]bool)
 
 	for snakeNo, snake := range next.Snakes {
 		if !snake.Alive {
 			continue
 		}
-		nextLocation := NextLocation(snake.Pieces[0], snakeDirections[snakeNo])
+		if snake.Phase > 0 {
+			snake.Phase--
+		}
+		snake.Direction = snakeDirections[snakeNo]
+		nextLocation, validMove := rules.Move(next, snake.Pieces[0], snakeDirections[snakeNo])
 		if nextLocation == s.Apple.Location {
 			snake.Length++
 			repositionApple = true
 		}
+		for itemNo, item := range next.Items {
+			if item.Location == nextLocation {
+				item.pickUp(snake)
+				eaten[itemNo] = true
+			}
+		}
 		if snake.Length > len(snake.Pieces) {
 			snake.Pieces = append(snake.Pieces, Location{})
+		} else if snake.Length < len(snake.Pieces) {
+			snake.Pieces = snake.Pieces[:snake.Length]
 		}
 		for i := len(snake.Pieces) - 1; i >= 1; i-- {
 			snake.Pieces[i] = snake.Pieces[i-1]
@@ -276,8 +384,11 @@ func (s *State) Next(snakeDirections []Direction) *State {
 		}
 		locPair := locationPair{snake.Pieces[0], nextLocation}
 		snake.Pieces[0] = nextLocation
-		if !nextLocation.IsInsideBounds(next.Width, next.Height) {
-			// collided with wall
+		if validMove && next.IsWall(nextLocation) {
+			validMove = false
+		}
+		if !validMove {
+			// collided with wall or arena edge
 			snake.Alive = false
 		} else if otherSnakeNo, ok := nextHeadLocations[nextLocation]; ok {
 			// two snakes tried to go to the same location
@@ -295,21 +406,47 @@ func (s *State) Next(snakeDirections []Direction) *State {
 
 	// Tail collisions
 	for loc, snakeNo := range nextHeadLocations {
-		if _, ok := tails[loc]; ok {
+		if _, ok := tails[loc]; ok && next.Snakes[snakeNo].Phase == 0 {
 			next.Snakes[snakeNo].Alive = false
 		}
 	}
 
+	if len(eaten) > 0 {
+		items := make([]Item, 0, len(next.Items))
+		for itemNo, item := range next.Items {
+			if !eaten[itemNo] {
+				items = append(items, item)
+			}
+		}
+		next.Items = items
+	}
+
 	if repositionApple {
-		next.Apple.Location = GenerateAppleLocation(next.Width, next.Height, next.Snakes)
+		if location, ok := next.place(next.Seed, next.occupied()); ok {
+			next.Apple.Location = location
+		} else {
+			next.Full = true
+		}
 	}
+	next.refillItems()
 
 	return next
 }
 
 // IsCompleted returns if the game is completed and which snake number is the winner.
 // -1 is returned as the snake winner if no snakes are left alive.
+//
+// A full arena (see State.Full) completes the game, and the longest snake is
+// the winner. -1 is returned as the winner if there is no single longest
+// snake.
 func (s *State) IsCompleted() (bool, int) {
+	if s.Full {
+		if winner, ok := s.LongestSnake(); ok {
+			return true, winner
+		}
+		return true, -1
+	}
+
 	alive := -1
 	nonAlive := true
 	for snakeNo, snake := range s.Snakes {
@@ -0,0 +1,315 @@
+package snakes
+
+import (
+	"testing"
+)
+
+// testSnake returns a live snake with the given pieces, head first.
+func testSnake(direction Direction, pieces ...Location) *Snake {
+	return &Snake{
+		Alive:     true,
+		Length:    len(pieces),
+		Pieces:    pieces,
+		Direction: direction,
+	}
+}
+
+// snakeResult is the expected state of a snake after State.Next.
+type snakeResult struct {
+	alive  bool
+	head   Location
+	length int
+	pieces int
+	phase  int
+}
+
+func TestStateNext(t *testing.T) {
+	tests := []struct {
+		name       string
+		ruleset    Ruleset
+		walls      []Location
+		items      []Item
+		snakes     []*Snake
+		directions []Direction
+		want       []snakeResult
+		// If the apple should have been eaten and moved.
+		appleMoved bool
+		// Number of items left in the arena.
+		itemsLeft int
+	}{
+		{
+			name: "move",
+			snakes: []*Snake{
+				testSnake(DirectionNorth, Location{5, 5}, Location{5, 6}),
+				testSnake(DirectionEast, Location{1, 1}, Location{0, 1}),
+			},
+			directions: []Direction{DirectionWest, DirectionEast},
+			want: []snakeResult{
+				{alive: true, head: Location{4, 5}, length: 2, pieces: 2},
+				{alive: true, head: Location{2, 1}, length: 2, pieces: 2},
+			},
+		},
+		{
+			name: "arena edge",
+			snakes: []*Snake{
+				testSnake(DirectionWest, Location{0, 5}, Location{1, 5}),
+			},
+			directions: []Direction{DirectionWest},
+			want: []snakeResult{
+				{alive: false, head: Location{-1, 5}, length: 2, pieces: 2},
+			},
+		},
+		{
+			name:    "wraparound edge",
+			ruleset: WraparoundRuleset{},
+			snakes: []*Snake{
+				testSnake(DirectionWest, Location{0, 5}, Location{1, 5}),
+				testSnake(DirectionNorth, Location{3, 0}, Location{3, 1}),
+			},
+			directions: []Direction{DirectionWest, DirectionNorth},
+			want: []snakeResult{
+				{alive: true, head: Location{9, 5}, length: 2, pieces: 2},
+				{alive: true, head: Location{3, 9}, length: 2, pieces: 2},
+			},
+		},
+		{
+			name:    "walls ruleset wall",
+			ruleset: WallsRuleset{},
+			walls:   []Location{{5, 4}},
+			snakes: []*Snake{
+				testSnake(DirectionNorth, Location{5, 5}, Location{5, 6}),
+			},
+			directions: []Direction{DirectionNorth},
+			want: []snakeResult{
+				{alive: false, head: Location{5, 4}, length: 2, pieces: 2},
+			},
+		},
+		{
+			name:  "map wall",
+			walls: []Location{{5, 4}},
+			snakes: []*Snake{
+				testSnake(DirectionNorth, Location{5, 5}, Location{5, 6}),
+			},
+			directions: []Direction{DirectionNorth},
+			want: []snakeResult{
+				{alive: false, head: Location{5, 4}, length: 2, pieces: 2},
+			},
+		},
+		{
+			name: "head-on collision",
+			snakes: []*Snake{
+				testSnake(DirectionEast, Location{4, 5}, Location{3, 5}),
+				testSnake(DirectionWest, Location{6, 5}, Location{7, 5}),
+			},
+			directions: []Direction{DirectionEast, DirectionWest},
+			want: []snakeResult{
+				{alive: false, head: Location{5, 5}, length: 2, pieces: 2},
+				{alive: false, head: Location{5, 5}, length: 2, pieces: 2},
+			},
+		},
+		{
+			name: "swapped heads",
+			snakes: []*Snake{
+				testSnake(DirectionEast, Location{4, 5}, Location{3, 5}),
+				testSnake(DirectionWest, Location{5, 5}, Location{6, 5}),
+			},
+			directions: []Direction{DirectionEast, DirectionWest},
+			want: []snakeResult{
+				{alive: false, head: Location{5, 5}, length: 2, pieces: 2},
+				{alive: false, head: Location{4, 5}, length: 2, pieces: 2},
+			},
+		},
+		{
+			name: "body collision",
+			snakes: []*Snake{
+				testSnake(DirectionEast, Location{5, 5}, Location{4, 5}),
+				testSnake(DirectionNorth, Location{6, 4}, Location{6, 5}, Location{6, 6}, Location{6, 7}),
+			},
+			directions: []Direction{DirectionEast, DirectionNorth},
+			want: []snakeResult{
+				{alive: false, head: Location{6, 5}, length: 2, pieces: 2},
+				{alive: true, head: Location{6, 3}, length: 4, pieces: 4},
+			},
+		},
+		{
+			name: "phase through body",
+			snakes: []*Snake{
+				{
+					Alive:     true,
+					Length:    2,
+					Pieces:    []Location{{5, 5}, {4, 5}},
+					Direction: DirectionEast,
+					Phase:     2,
+				},
+				testSnake(DirectionNorth, Location{6, 4}, Location{6, 5}, Location{6, 6}, Location{6, 7}),
+			},
+			directions: []Direction{DirectionEast, DirectionNorth},
+			want: []snakeResult{
+				{alive: true, head: Location{6, 5}, length: 2, pieces: 2, phase: 1},
+				{alive: true, head: Location{6, 3}, length: 4, pieces: 4},
+			},
+		},
+		{
+			name: "following own tail",
+			snakes: []*Snake{
+				testSnake(DirectionWest, Location{5, 5}, Location{6, 5}, Location{6, 6}, Location{5, 6}),
+			},
+			directions: []Direction{DirectionSouth},
+			want: []snakeResult{
+				{alive: true, head: Location{5, 6}, length: 4, pieces: 4},
+			},
+		},
+		{
+			name: "apple",
+			snakes: []*Snake{
+				testSnake(DirectionNorth, Location{5, 3}, Location{5, 4}),
+			},
+			directions: []Direction{DirectionNorth},
+			want: []snakeResult{
+				{alive: true, head: Location{5, 2}, length: 3, pieces: 3},
+			},
+			appleMoved: true,
+		},
+		{
+			name: "golden apple",
+			items: []Item{
+				{Type: ItemGoldenApple, Location: Location{5, 4}},
+				{Type: ItemApple, Location: Location{0, 0}},
+			},
+			snakes: []*Snake{
+				testSnake(DirectionNorth, Location{5, 5}, Location{5, 6}),
+			},
+			directions: []Direction{DirectionNorth},
+			want: []snakeResult{
+				{alive: true, head: Location{5, 4}, length: 5, pieces: 3},
+			},
+			itemsLeft: 1,
+		},
+		{
+			name: "shrink",
+			items: []Item{
+				{Type: ItemShrink, Location: Location{5, 4}},
+			},
+			snakes: []*Snake{
+				testSnake(DirectionNorth, Location{5, 5}, Location{5, 6}, Location{5, 7}, Location{5, 8}),
+			},
+			directions: []Direction{DirectionNorth},
+			want: []snakeResult{
+				{alive: true, head: Location{5, 4}, length: 2, pieces: 2},
+			},
+		},
+		{
+			name: "shrink below one piece",
+			items: []Item{
+				{Type: ItemShrink, Location: Location{5, 4}},
+			},
+			snakes: []*Snake{
+				testSnake(DirectionNorth, Location{5, 5}, Location{5, 6}),
+			},
+			directions: []Direction{DirectionNorth},
+			want: []snakeResult{
+				{alive: true, head: Location{5, 4}, length: 1, pieces: 1},
+			},
+		},
+		{
+			name: "phase item",
+			items: []Item{
+				{Type: ItemPhase, Location: Location{5, 4}},
+			},
+			snakes: []*Snake{
+				testSnake(DirectionNorth, Location{5, 5}, Location{5, 6}),
+			},
+			directions: []Direction{DirectionNorth},
+			want: []snakeResult{
+				{alive: true, head: Location{5, 4}, length: 2, pieces: 2, phase: PhaseDuration},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apple := Apple{Location: Location{9, 9}}
+			if test.appleMoved {
+				apple.Location = NextLocation(test.snakes[0].Pieces[0], test.directions[0])
+			}
+			state := &State{
+				Width:   10,
+				Height:  10,
+				Snakes:  test.snakes,
+				Apple:   apple,
+				Walls:   test.walls,
+				Items:   test.items,
+				Ruleset: test.ruleset,
+			}
+
+			next := state.Next(test.directions)
+
+			if next.Tick != 1 {
+				t.Errorf("Tick = %d, want 1", next.Tick)
+			}
+			for i, want := range test.want {
+				snake := next.Snakes[i]
+				if snake.Alive != want.alive {
+					t.Errorf("snake %d: Alive = %v, want %v", i, snake.Alive, want.alive)
+				}
+				if snake.Pieces[0] != want.head {
+					t.Errorf("snake %d: head = %v, want %v", i, snake.Pieces[0], want.head)
+				}
+				if snake.Length != want.length {
+					t.Errorf("snake %d: Length = %d, want %d", i, snake.Length, want.length)
+				}
+				if len(snake.Pieces) != want.pieces {
+					t.Errorf("snake %d: %d pieces, want %d", i, len(snake.Pieces), want.pieces)
+				}
+				if snake.Phase != want.phase {
+					t.Errorf("snake %d: Phase = %d, want %d", i, snake.Phase, want.phase)
+				}
+			}
+			if moved := next.Apple != state.Apple; moved != test.appleMoved {
+				t.Errorf("apple moved = %v, want %v", moved, test.appleMoved)
+			}
+			if len(next.Items) != test.itemsLeft {
+				t.Errorf("%d items, want %d", len(next.Items), test.itemsLeft)
+			}
+		})
+	}
+}
+
+func TestStateNextDoesNotModifyState(t *testing.T) {
+	state := &State{
+		Width:  10,
+		Height: 10,
+		Snakes: []*Snake{
+			testSnake(DirectionNorth, Location{5, 5}, Location{5, 6}),
+			testSnake(DirectionNorth, Location{2, 5}, Location{2, 6}),
+		},
+		Apple: Apple{Location: Location{5, 4}},
+	}
+
+	state.Next([]Direction{DirectionNorth, DirectionWest})
+
+	if state.Tick != 0 {
+		t.Errorf("Tick = %d, want 0", state.Tick)
+	}
+	if head := state.Snakes[0].Pieces[0]; head != (Location{5, 5}) {
+		t.Errorf("head = %v, want {5 5}", head)
+	}
+	if state.Snakes[0].Length != 2 || state.Snakes[1].Direction != DirectionNorth {
+		t.Errorf("snakes were modified: %+v, %+v", state.Snakes[0], state.Snakes[1])
+	}
+	if state.Apple.Location != (Location{5, 4}) {
+		t.Errorf("apple moved to %v", state.Apple.Location)
+	}
+}
+
+func TestNewStateNoSpawnLocation(t *testing.T) {
+	_, err := NewState(StateConfig{
+		Width:              2,
+		Height:             1,
+		SnakeCount:         3,
+		InitialSnakeLength: 1,
+	})
+	if err != ErrNoSpawnLocation {
+		t.Fatalf("err = %v, want ErrNoSpawnLocation", err)
+	}
+}
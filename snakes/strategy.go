@@ -0,0 +1,141 @@
+package snakes
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Strategy is the decision making logic of a bot.
+type Strategy interface {
+	// Decide returns the direction in which the bot named self should move,
+	// given the current state of the arena.
+	Decide(state *RoundStateMessage, self string) Direction
+}
+
+// StrategyFunc is an adapter to allow the use of ordinary functions as a
+// Strategy.
+type StrategyFunc func(state *RoundStateMessage, self string) Direction
+
+// Decide calls f(state, self).
+func (f StrategyFunc) Decide(state *RoundStateMessage, self string) Direction {
+	return f(state, self)
+}
+
+// Play runs a strategy over the bot's connection. A new strategy is created
+// with newStrategy at the start of every round, with a seed drawn from seed,
+// and the strategy's decision is sent to the server each turn.
+//
+// Moves that can not be sent are skipped, as the bot reconnects in the
+// background when its connection is lost. The function returns once the bot
+// stops receiving rounds, with the error that caused the connection to close.
+func (w *WebSocketBot) Play(seed int64, newStrategy func(seed int64) Strategy) error {
+	rng := rand.New(rand.NewSource(seed))
+	for round := range w.Rounds() {
+		strategy := newStrategy(rng.Int63())
+		for turn := range round.Turns() {
+			turn.Move(strategy.Decide(turn.RoundStateMessage, w.name))
+		}
+	}
+	return w.Err()
+}
+
+// StrategyClient is a server-side Client that is controlled by a Strategy.
+//
+// The strategy is run when the client is sent the round state by the
+// server's send queue, so it must return within a round tick.
+type StrategyClient struct {
+	name        string
+	newStrategy func(seed int64) Strategy
+
+	mu        sync.Mutex
+	rng       *rand.Rand
+	strategy  Strategy
+	direction Direction
+	// Tick of the latest round state that the strategy has decided on, or
+	// -1 between rounds.
+	tick int
+	// pending is returned by Moved for pendingTick, a tick that the
+	// strategy has not decided on yet, and is closed once it has.
+	pending     chan struct{}
+	pendingTick int
+}
+
+var (
+	_ Client            = (*StrategyClient)(nil)
+	_ MoveWaiter        = (*StrategyClient)(nil)
+	_ DirectionResetter = (*StrategyClient)(nil)
+)
+
+// NewStrategyClient creates a new StrategyClient with the given name. A new
+// strategy is created with newStrategy at the start of every round, with a
+// seed drawn from seed.
+func NewStrategyClient(name string, seed int64, newStrategy func(seed int64) Strategy) *StrategyClient {
+	return &StrategyClient{
+		name:        name,
+		newStrategy: newStrategy,
+		rng:         rand.New(rand.NewSource(seed)),
+		tick:        -1,
+	}
+}
+
+// ID implements Client.
+func (c *StrategyClient) ID() string {
+	return c.name
+}
+
+// Direction implements Client.
+func (c *StrategyClient) Direction() Direction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.direction
+}
+
+// ResetDirection implements DirectionResetter.
+func (c *StrategyClient) ResetDirection(d Direction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.direction = d
+}
+
+// Moved implements MoveWaiter. The strategy decides its move as soon as it
+// is sent the round state.
+func (c *StrategyClient) Moved(tick int) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if tick <= c.tick {
+		return closedChan
+	}
+	if c.pending == nil || c.pendingTick != tick {
+		c.pending = make(chan struct{})
+		c.pendingTick = tick
+	}
+	return c.pending
+}
+
+// SendMessage implements ViewerClient.
+func (c *StrategyClient) SendMessage(msg *Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case msg.RoundStateMessage != nil:
+		c.tick = msg.RoundStateMessage.Tick
+		if c.pending != nil && c.pendingTick <= c.tick {
+			defer close(c.pending)
+			c.pending = nil
+		}
+		player := msg.RoundStateMessage.Player(c.name)
+		if player == nil || len(player.Pieces) == 0 {
+			break
+		}
+		if c.strategy == nil {
+			c.strategy = c.newStrategy(c.rng.Int63())
+		}
+		c.direction = c.strategy.Decide(msg.RoundStateMessage, c.name)
+	default:
+		c.strategy = nil
+		c.tick = -1
+	}
+	return nil
+}
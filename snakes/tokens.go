@@ -0,0 +1,150 @@
+package snakes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Errors returned by TokenStore.Verify.
+var (
+	ErrUnregisteredBot = errors.New("unregistered bot name")
+	ErrInvalidToken    = errors.New("invalid bot token")
+)
+
+// TokenStore contains the registered bot names and their API tokens, and
+// persists them to a JSON file.
+//
+// Only a hash of each token is stored.
+type TokenStore struct {
+	mu     sync.Mutex
+	path   string
+	hashes map[string]string
+}
+
+// NewFileTokenStore creates a TokenStore that is stored in the file at path.
+// The existing tokens are loaded from the file if it exists.
+func NewFileTokenStore(path string) (*TokenStore, error) {
+	t := &TokenStore{
+		path:   path,
+		hashes: make(map[string]string),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &t.hashes); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of the token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Mint creates a new token for the bot with the given name. Any existing
+// token for the bot is replaced.
+func (t *TokenStore) Mint(name string) (string, error) {
+	if !validBotName(name) {
+		return "", errors.New("invalid snake name")
+	}
+
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b[:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	previous, existed := t.hashes[name]
+	t.hashes[name] = hashToken(token)
+	if err := t.save(); err != nil {
+		if existed {
+			t.hashes[name] = previous
+		} else {
+			delete(t.hashes, name)
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+// Revoke removes the token of the bot with the given name. false is returned
+// if the bot is not registered. Bots that are already connected are not
+// affected; the caller should kick them from the servers it runs.
+func (t *TokenStore) Revoke(name string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hash, ok := t.hashes[name]
+	if !ok {
+		return false, nil
+	}
+	delete(t.hashes, name)
+	if err := t.save(); err != nil {
+		t.hashes[name] = hash
+		return false, err
+	}
+	return true, nil
+}
+
+// Names returns the sorted names of the registered bots.
+func (t *TokenStore) Names() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	names := make([]string, 0, len(t.hashes))
+	for name := range t.hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Verify checks that token is the token of the bot with the given name.
+//
+// ErrUnregisteredBot is returned if the bot is not registered, and
+// ErrInvalidToken is returned if the token does not match.
+func (t *TokenStore) Verify(name, token string) error {
+	t.mu.Lock()
+	hash, ok := t.hashes[name]
+	t.mu.Unlock()
+
+	if !ok {
+		return ErrUnregisteredBot
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(token))) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// save writes the tokens to the token file.
+func (t *TokenStore) save() error {
+	b, err := json.MarshalIndent(t.hashes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := t.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
@@ -1,5 +1,10 @@
 package snakes
 
+import (
+	"net/http"
+	"strconv"
+)
+
 // ClientMessage is a message sent from a WebSocketClient to
 // a WebSocket server.
 type ClientMessage struct {
@@ -10,4 +15,71 @@ type ClientMessage struct {
 // wishes to move their snake on the game board.
 type DirectionClientMessage struct {
 	Direction Direction `json:"direction"`
+	// Tick of the RoundStateMessage that the move is in response to. If
+	// set, the move is rejected if it does not match the latest tick.
+	Tick *int `json:"tick,omitempty"`
+	// If true, the server responds with a MoveAckMessage.
+	Ack bool `json:"ack,omitempty"`
+}
+
+// ViewerControlMessage is a message sent from a viewer to a WebSocketViewer
+// to control playback of the rounds in the server's history.
+type ViewerControlMessage struct {
+	// Command is one of:
+	//  - "pause": stop following the live round, and hold the current tick
+	//  - "resume": play the round forward from the current tick; playback
+	//    returns to live once it reaches the round in progress
+	//  - "step": move Ticks ticks (default 1, may be negative) and pause
+	//  - "seek": move to tick Tick of the current round and pause
+	//  - "round": move to the start of round Round and pause
+	//  - "live": follow the live round
+	Command string `json:"command"`
+	Tick    int    `json:"tick,omitempty"`
+	Ticks   int    `json:"ticks,omitempty"`
+	Round   int    `json:"round,omitempty"`
+}
+
+// RoomName returns the name of the room requested by the WebSocket
+// connection's HTTP request. The name is read from the X-Snake-Room header,
+// falling back to the room query parameter.
+//
+// An empty string is returned if no room was requested.
+func RoomName(r *http.Request) string {
+	if name := r.Header.Get("X-Snake-Room"); name != "" {
+		return name
+	}
+	return r.URL.Query().Get("room")
+}
+
+// BotToken returns the API token presented by the WebSocket connection's HTTP
+// request. The token is read from the X-Snake-Token header, falling back to
+// the token query parameter.
+func BotToken(r *http.Request) string {
+	if token := r.Header.Get("X-Snake-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// SessionToken returns the session token presented by the WebSocket
+// connection's HTTP request. The token is read from the X-Snake-Session
+// header, falling back to the session query parameter.
+func SessionToken(r *http.Request) string {
+	if token := r.Header.Get("X-Snake-Session"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("session")
+}
+
+// DeltaUpdates returns if the WebSocket connection's HTTP request asked to
+// receive RoundDeltaMessages instead of a full RoundStateMessage every tick.
+// The request is read from the X-Snake-Deltas header, falling back to the
+// deltas query parameter.
+func DeltaUpdates(r *http.Request) bool {
+	value := r.Header.Get("X-Snake-Deltas")
+	if value == "" {
+		value = r.URL.Query().Get("deltas")
+	}
+	enabled, _ := strconv.ParseBool(value)
+	return enabled
 }
@@ -3,8 +3,11 @@ package snakes
 import (
 	"errors"
 	"io"
+	"net"
 	"net/http"
+	"sync"
 	"sync/atomic"
+	"time"
 	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
@@ -12,13 +15,43 @@ import (
 
 // WebSocketClient is a WebSocket based Client.
 type WebSocketClient struct {
-	c    *websocket.Conn
+	mu   sync.Mutex
+	c    *wsConn
 	name string
 
 	direction int32
+
+	// deltas is non-nil if the client requested delta updates. Protected by
+	// mu.
+	deltas *deltaEncoder
+
+	// Latest tick sent to the client, and when it was sent. Protected by mu.
+	tick     int
+	tickSent time.Time
+	// moved is closed once a move has been accepted for tick. It is nil
+	// between rounds. Protected by mu.
+	moved      chan struct{}
+	movedClose sync.Once
+	// pending is returned by Moved for pendingTick, a tick that has not been
+	// sent to the client yet, and becomes moved once the tick is sent.
+	// Protected by mu.
+	pending     chan struct{}
+	pendingTick int
 }
 
-var _ Client = (*WebSocketClient)(nil)
+var (
+	_ Client            = (*WebSocketClient)(nil)
+	_ MoveWaiter        = (*WebSocketClient)(nil)
+	_ DirectionResetter = (*WebSocketClient)(nil)
+	_ io.Closer         = (*WebSocketClient)(nil)
+)
+
+// closedChan is a channel that is always closed.
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
 
 func validBotName(name string) bool {
 	if len(name) == 0 {
@@ -35,6 +68,9 @@ func validBotName(name string) bool {
 
 // NewWebSocketClient creates a new WebSocketClient from the given WebSocket connection.
 //
+// The client is sent RoundDeltaMessages if the HTTP request asks for delta
+// updates (see DeltaUpdates).
+//
 // nil and an error is returned if the HTTP request does not contain
 // a valid name in the X-Snake-Name header.
 func NewWebSocketClient(conn *websocket.Conn, r *http.Request) (*WebSocketClient, error) {
@@ -44,21 +80,53 @@ func NewWebSocketClient(conn *websocket.Conn, r *http.Request) (*WebSocketClient
 	}
 
 	c := &WebSocketClient{
-		c:    conn,
+		c:    newWSConn(conn),
 		name: snakeName,
 	}
+	if DeltaUpdates(r) {
+		c.deltas = &deltaEncoder{}
+	}
 
 	return c, nil
 }
 
+// ErrConnectionReplaced is returned by WebSocketClient.Run when the
+// connection it was reading from has been replaced by Resume.
+var ErrConnectionReplaced = errors.New("connection replaced by a resumed session")
+
+// Resume replaces the client's WebSocket connection with conn, after the
+// client has reconnected. The previous connection is closed, if it is still
+// open. Run must be called again to read from the new connection.
+func (s *WebSocketClient) Resume(conn *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.c.Close()
+	s.c = newWSConn(conn)
+	if s.deltas != nil {
+		s.deltas.reset()
+	}
+}
+
+// conn returns the client's current WebSocket connection.
+func (s *WebSocketClient) conn() *wsConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c
+}
+
 // Run continuously reads client messages from the WebSocket connection.
-// An error is returned on the first error reading from the connection.
+// An error is returned on the first error reading from the connection, or
+// ErrConnectionReplaced if the connection was replaced by Resume.
 func (s *WebSocketClient) Run() error {
+	conn := s.conn()
 	for {
 		var msg ClientMessage
-		err := s.c.ReadJSON(&msg)
+		err := conn.readJSON(&msg)
 		if err != nil {
-			if err == io.ErrUnexpectedEOF {
+			if s.conn() != conn {
+				return ErrConnectionReplaced
+			}
+			if err == io.ErrUnexpectedEOF || errors.Is(err, net.ErrClosed) || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 				return nil
 			}
 			return err
@@ -66,13 +134,49 @@ func (s *WebSocketClient) Run() error {
 
 		switch {
 		case msg.DirectionClientMessage != nil:
-			atomic.StoreInt32(&s.direction, int32(msg.DirectionClientMessage.Direction))
+			if err := s.move(msg.DirectionClientMessage); err != nil {
+				return err
+			}
 		default:
 			return errors.New("invalid client message")
 		}
 	}
+}
+
+// move applies the direction message, rejecting it if it is not for the
+// latest tick sent to the client.
+func (s *WebSocketClient) move(msg *DirectionClientMessage) error {
+	s.mu.Lock()
+	conn := s.c
+
+	ack := &MoveAckMessage{
+		Tick:      s.tick,
+		Accepted:  true,
+		LatencyMS: int64(time.Since(s.tickSent) / time.Millisecond),
+	}
+	if msg.Tick != nil {
+		ack.Tick = *msg.Tick
+		ack.TicksBehind = s.tick - *msg.Tick
+		ack.Stale = ack.TicksBehind > 0
+		ack.Accepted = ack.TicksBehind == 0
+	}
+	if ack.Accepted {
+		atomic.StoreInt32(&s.direction, int32(msg.Direction))
+		if s.moved != nil {
+			s.movedClose.Do(func() {
+				close(s.moved)
+			})
+		}
+	}
+
+	s.mu.Unlock()
 
-	return nil
+	if !msg.Ack {
+		return nil
+	}
+	return conn.writeMessage(&Message{
+		MoveAck: ack,
+	})
 }
 
 // ID returns the client's name as provided by the X-Snake-Name header
@@ -87,7 +191,71 @@ func (s *WebSocketClient) Direction() Direction {
 	return Direction(atomic.LoadInt32(&s.direction))
 }
 
+// ResetDirection implements DirectionResetter.
+func (s *WebSocketClient) ResetDirection(d Direction) {
+	atomic.StoreInt32(&s.direction, int32(d))
+}
+
+// Moved implements MoveWaiter. Moves that are not tagged with a tick count
+// as moves for the latest tick sent to the client.
+func (s *WebSocketClient) Moved(tick int) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.moved != nil {
+		switch {
+		case tick < s.tick:
+			return closedChan
+		case tick == s.tick:
+			return s.moved
+		}
+	}
+
+	// The tick is still waiting in the server's send queue
+	if s.pending == nil || s.pendingTick != tick {
+		s.pending = make(chan struct{})
+		s.pendingTick = tick
+	}
+	return s.pending
+}
+
+// Close closes the client's WebSocket connection.
+func (s *WebSocketClient) Close() error {
+	return s.conn().Close()
+}
+
+// kick implements kicker. The connection is closed as a policy violation, so
+// that WebSocketBot does not reconnect.
+func (s *WebSocketClient) kick() error {
+	return s.conn().closeWithCode(websocket.ClosePolicyViolation, "kicked")
+}
+
+// RemoteAddr returns the address of the client's current connection.
+func (s *WebSocketClient) RemoteAddr() net.Addr {
+	return s.conn().ws.RemoteAddr()
+}
+
 // SendMessage sends the message to the client.
 func (s *WebSocketClient) SendMessage(msg *Message) error {
-	return s.c.WriteJSON(msg)
+	s.mu.Lock()
+
+	if msg.RoundStateMessage != nil {
+		s.tick = msg.RoundStateMessage.Tick
+		s.tickSent = time.Now()
+		s.moved = make(chan struct{})
+		if s.pending != nil && s.pendingTick == s.tick {
+			s.moved = s.pending
+		}
+		s.pending = nil
+		s.movedClose = sync.Once{}
+	} else if msg.RoundOverMessage != nil {
+		s.moved = nil
+	}
+	if s.deltas != nil {
+		msg = s.deltas.encode(msg)
+	}
+	conn := s.c
+	s.mu.Unlock()
+
+	return conn.writeMessage(msg)
 }
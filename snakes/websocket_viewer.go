@@ -1,43 +1,238 @@
 package snakes
 
 import (
+	"encoding/json"
+	"errors"
 	"io"
+	"net"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 // WebSocketViewer is a WebSocket based ViewerClient implementation.
+//
+// If the viewer has a RoundHistory (see SetHistory), the remote viewer can
+// control playback by sending ViewerControlMessages.
 type WebSocketViewer struct {
-	c *websocket.Conn
+	c *wsConn
+
+	history RoundHistory
+
+	mu      sync.Mutex
+	deltas  *deltaEncoder
+	live    bool
+	playing bool
+	round   int
+	tick    int
 }
 
 // NewWebSocketViewer creates a new WebSocketViewer around the given
 // WebSocket connection.
 func NewWebSocketViewer(conn *websocket.Conn) *WebSocketViewer {
 	return &WebSocketViewer{
-		c: conn,
+		c:    newWSConn(conn),
+		live: true,
 	}
 }
 
-var _ ViewerClient = (*WebSocketViewer)(nil)
+var (
+	_ ViewerClient = (*WebSocketViewer)(nil)
+	_ io.Closer    = (*WebSocketViewer)(nil)
+)
+
+// SetHistory sets the round history that the viewer can play back. It must
+// be called before Run.
+func (v *WebSocketViewer) SetHistory(h RoundHistory) {
+	v.history = h
+}
+
+// SetDeltas sets if the viewer is sent RoundDeltaMessages instead of a full
+// RoundStateMessage every tick (see DeltaUpdates). It must be called before
+// the viewer is added to a server.
+func (v *WebSocketViewer) SetDeltas(enabled bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.deltas = nil
+	if enabled {
+		v.deltas = &deltaEncoder{}
+	}
+}
 
-// Run keeps the underlying WebSocket connection alive.
+// Run reads playback control messages from the underlying WebSocket
+// connection. Invalid messages are ignored.
 // It returns when the underlying WebSocket connection closes.
 func (v *WebSocketViewer) Run() error {
-	for {
-		var val interface{}
+	if v.history != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go v.playback(done)
+	}
 
-		// Keep connection alive
-		if err := v.c.ReadJSON(&val); err != nil {
-			if err == io.ErrUnexpectedEOF {
+	for {
+		b, err := v.c.read()
+		if err != nil {
+			if err == io.ErrUnexpectedEOF || errors.Is(err, net.ErrClosed) || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 				err = nil
 			}
 			return err
 		}
+
+		var msg ViewerControlMessage
+		if v.history == nil || json.Unmarshal(b, &msg) != nil {
+			continue
+		}
+		v.control(&msg)
 	}
 }
 
-// SendMessage sends the message to the client.
+// Close closes the viewer's WebSocket connection.
+func (v *WebSocketViewer) Close() error {
+	return v.c.Close()
+}
+
+// RemoteAddr returns the address of the viewer's connection.
+func (v *WebSocketViewer) RemoteAddr() net.Addr {
+	return v.c.ws.RemoteAddr()
+}
+
+// SendMessage sends the message to the client. Messages are not sent while
+// the viewer is watching a historical round.
 func (v *WebSocketViewer) SendMessage(msg *Message) error {
-	return v.c.WriteJSON(msg)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !v.live {
+		return nil
+	}
+	return v.write(msg)
+}
+
+// write sends a broadcast or historical message to the viewer, converting
+// it to a delta if the viewer requested delta updates. v.mu must be held.
+func (v *WebSocketViewer) write(msg *Message) error {
+	if v.deltas != nil {
+		msg = v.deltas.encode(msg)
+	}
+	return v.c.writeMessage(msg)
+}
+
+// control applies a playback control message.
+func (v *WebSocketViewer) control(msg *ViewerControlMessage) {
+	rounds := v.history.RoundIDs()
+	if len(rounds) == 0 {
+		return
+	}
+
+	latestRound := rounds[len(rounds)-1]
+	latestTick := len(v.history.RoundMessages(latestRound)) - 1
+
+	v.mu.Lock()
+	round, tick := v.round, v.tick
+	if v.live {
+		// Start from the latest tick of the live round
+		round, tick = latestRound, latestTick
+	}
+	v.mu.Unlock()
+
+	live, playing := false, false
+	switch msg.Command {
+	case "pause":
+	case "resume":
+		playing = true
+	case "step":
+		if msg.Ticks == 0 {
+			msg.Ticks = 1
+		}
+		tick += msg.Ticks
+	case "seek":
+		tick = msg.Tick
+	case "round":
+		round, tick = msg.Round, 0
+	case "live":
+		live = true
+	default:
+		return
+	}
+
+	messages := v.history.RoundMessages(round)
+	if messages == nil {
+		round = latestRound
+		messages = v.history.RoundMessages(round)
+	}
+	if tick >= len(messages) {
+		tick = len(messages) - 1
+	}
+	if tick < 0 {
+		tick = 0
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.live, v.playing, v.round, v.tick = live, playing, round, tick
+	if !live && len(messages) > 0 {
+		v.write(messages[tick])
+	}
+	v.sendStatus(rounds, len(messages))
+}
+
+// playback plays the historical round forward while the viewer is playing.
+func (v *WebSocketViewer) playback(done <-chan struct{}) {
+	tick := v.history.RoundTick()
+	if tick <= 0 {
+		tick = time.Millisecond * 200
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		v.mu.Lock()
+		playing, round := v.playing && !v.live, v.round
+		v.mu.Unlock()
+		if !playing {
+			continue
+		}
+
+		rounds := v.history.RoundIDs()
+		messages := v.history.RoundMessages(round)
+
+		v.mu.Lock()
+		if v.playing && !v.live && v.round == round {
+			if v.tick+1 < len(messages) {
+				v.tick++
+				v.write(messages[v.tick])
+			} else if len(rounds) > 0 && rounds[len(rounds)-1] == round {
+				// Caught up with the latest round
+				v.live, v.playing = true, false
+				v.sendStatus(rounds, len(messages))
+			} else {
+				v.playing = false
+				v.sendStatus(rounds, len(messages))
+			}
+		}
+		v.mu.Unlock()
+	}
+}
+
+// sendStatus sends the viewer's playback status. v.mu must be held.
+func (v *WebSocketViewer) sendStatus(rounds []int, ticks int) error {
+	return v.c.writeMessage(&Message{
+		ViewerStatus: &ViewerStatusMessage{
+			Live:   v.live,
+			Paused: !v.live && !v.playing,
+			Round:  v.round,
+			Tick:   v.tick,
+			Ticks:  ticks,
+			Rounds: rounds,
+		},
+	})
 }
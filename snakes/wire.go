@@ -0,0 +1,277 @@
+package snakes
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket subprotocols that select how Messages are encoded on a
+// connection.
+//
+// With JSONSubprotocol (or if no subprotocol is negotiated), each Message is
+// sent as a JSON text message. With BinarySubprotocol, each Message is sent
+// as a binary message encoded by MarshalBinaryMessage.
+//
+// Messages sent to the server are always JSON.
+const (
+	JSONSubprotocol   = "snakes.json"
+	BinarySubprotocol = "snakes.binary"
+)
+
+// Subprotocols contains the supported subprotocols, in order of preference.
+// It can be used as the Subprotocols of a websocket.Upgrader or
+// websocket.Dialer.
+var Subprotocols = []string{BinarySubprotocol, JSONSubprotocol}
+
+// Binary message kinds. The first byte of a binary encoded Message is its
+// kind.
+const (
+	// The rest of the message is the JSON encoded Message.
+	binaryKindJSON byte = iota
+	// The rest of the message is a binary encoded RoundStateMessage.
+	binaryKindRoundState
+)
+
+// MarshalBinaryMessage encodes the message for BinarySubprotocol.
+//
+// RoundStateMessages are encoded as a sequence of varints: tick, width,
+// height, the number of players, then each player's name length, name bytes,
+// number of pieces, piece locations, direction and phase, followed by the
+// apple location, the number of walls and wall locations, the seconds
+// remaining plus one (zero if there is no time limit), and the number of
+// items followed by each item's type length, type bytes, location and expiry
+// tick. Lengths and counts are unsigned varints, and all other numbers are
+// signed varints. Other messages are encoded as JSON.
+func MarshalBinaryMessage(msg *Message) ([]byte, error) {
+	if msg.RoundStateMessage == nil {
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{binaryKindJSON}, b...), nil
+	}
+
+	m := msg.RoundStateMessage
+	e := binaryEncoder{
+		b: []byte{binaryKindRoundState},
+	}
+	e.int(m.Tick)
+	e.int(m.Width)
+	e.int(m.Height)
+	e.uint(len(m.Players))
+	for _, player := range m.Players {
+		e.uint(len(player.Name))
+		e.b = append(e.b, player.Name...)
+		e.locations(player.Pieces)
+		e.uint(int(player.Direction))
+		e.uint(player.Phase)
+	}
+	e.location(m.Apple.Location)
+	e.locations(m.Walls)
+	if m.SecondsRemaining != nil {
+		e.uint(*m.SecondsRemaining + 1)
+	} else {
+		e.uint(0)
+	}
+	e.uint(len(m.Items))
+	for _, item := range m.Items {
+		e.uint(len(item.Type))
+		e.b = append(e.b, item.Type...)
+		e.location(item.Location)
+		e.int(item.ExpiresTick)
+	}
+	return e.b, nil
+}
+
+// UnmarshalBinaryMessage decodes a message encoded by MarshalBinaryMessage.
+func UnmarshalBinaryMessage(b []byte) (*Message, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty message")
+	}
+
+	var msg Message
+	switch b[0] {
+	case binaryKindJSON:
+		if err := json.Unmarshal(b[1:], &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	case binaryKindRoundState:
+	default:
+		return nil, errors.New("unknown binary message kind")
+	}
+
+	d := binaryDecoder{
+		b: b[1:],
+	}
+	m := &RoundStateMessage{
+		Tick:   d.int(),
+		Width:  d.int(),
+		Height: d.int(),
+	}
+	m.Players = make([]*RoundStateMessagePlayer, d.count())
+	for i := range m.Players {
+		m.Players[i] = &RoundStateMessagePlayer{
+			Name:      string(d.bytes(d.count())),
+			Pieces:    d.locations(),
+			Direction: Direction(d.uint()),
+			Phase:     int(d.uint()),
+		}
+	}
+	m.Apple.Location = d.location()
+	m.Walls = d.locations()
+	if seconds := int(d.uint()); seconds > 0 {
+		seconds--
+		m.SecondsRemaining = &seconds
+	}
+	if n := d.count(); n > 0 {
+		m.Items = make([]Item, n)
+		for i := range m.Items {
+			m.Items[i] = Item{
+				Type:        ItemType(d.bytes(d.count())),
+				Location:    d.location(),
+				ExpiresTick: d.int(),
+			}
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	msg.RoundStateMessage = m
+	return &msg, nil
+}
+
+type binaryEncoder struct {
+	b []byte
+}
+
+func (e *binaryEncoder) uint(v int) {
+	var buf [binary.MaxVarintLen64]byte
+	e.b = append(e.b, buf[:binary.PutUvarint(buf[:], uint64(v))]...)
+}
+
+func (e *binaryEncoder) int(v int) {
+	var buf [binary.MaxVarintLen64]byte
+	e.b = append(e.b, buf[:binary.PutVarint(buf[:], int64(v))]...)
+}
+
+func (e *binaryEncoder) location(l Location) {
+	e.int(l.X)
+	e.int(l.Y)
+}
+
+func (e *binaryEncoder) locations(locations []Location) {
+	e.uint(len(locations))
+	for _, l := range locations {
+		e.location(l)
+	}
+}
+
+// binaryDecoder decodes the values written by binaryEncoder. Once an error
+// occurs, err is set and zero values are returned.
+type binaryDecoder struct {
+	b   []byte
+	err error
+}
+
+var errShortBinaryMessage = errors.New("short binary message")
+
+func (d *binaryDecoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errShortBinaryMessage
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// count decodes a length or count, which must not be larger than the
+// remaining message.
+func (d *binaryDecoder) count() int {
+	v := d.uint()
+	if v > uint64(len(d.b)) {
+		d.err = errShortBinaryMessage
+		return 0
+	}
+	return int(v)
+}
+
+func (d *binaryDecoder) int() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errShortBinaryMessage
+		return 0
+	}
+	d.b = d.b[n:]
+	return int(v)
+}
+
+func (d *binaryDecoder) bytes(n int) []byte {
+	if d.err != nil || n > len(d.b) {
+		d.err = errShortBinaryMessage
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *binaryDecoder) location() Location {
+	return Location{
+		X: d.int(),
+		Y: d.int(),
+	}
+}
+
+func (d *binaryDecoder) locations() []Location {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	locations := make([]Location, n)
+	for i := range locations {
+		locations[i] = d.location()
+	}
+	return locations
+}
+
+// writeMessage writes the message to the connection, using the encoding of
+// the connection's subprotocol.
+func writeMessage(c *websocket.Conn, msg *Message) error {
+	if c.Subprotocol() != BinarySubprotocol {
+		return c.WriteJSON(msg)
+	}
+	b, err := MarshalBinaryMessage(msg)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.BinaryMessage, b)
+}
+
+// readMessage reads a JSON or binary encoded message from the connection.
+func readMessage(c *websocket.Conn) (*Message, error) {
+	messageType, b, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if messageType == websocket.BinaryMessage {
+		return UnmarshalBinaryMessage(b)
+	}
+
+	var msg Message
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
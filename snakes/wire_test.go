@@ -0,0 +1,133 @@
+package snakes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBinaryMessageRoundTrip(t *testing.T) {
+	seconds := 12
+	noSeconds := 0
+	winner := "bob"
+
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{
+			name: "round state",
+			msg: &Message{
+				RoundStateMessage: &RoundStateMessage{
+					Tick:   42,
+					Width:  40,
+					Height: 20,
+					Players: []*RoundStateMessagePlayer{
+						{
+							Name:      "alice",
+							Pieces:    []Location{{3, 4}, {3, 5}, {3, 6}},
+							Direction: DirectionNorth,
+							Phase:     3,
+						},
+						{
+							Name:      "bob",
+							Direction: DirectionWest,
+						},
+					},
+					Apple: Apple{Location: Location{10, 11}},
+					Walls: []Location{{0, 0}, {39, 19}},
+					Items: []Item{
+						{Type: ItemGoldenApple, Location: Location{7, 8}},
+						{Type: ItemShrink, Location: Location{1, 2}, ExpiresTick: 90},
+					},
+					SecondsRemaining: &seconds,
+				},
+			},
+		},
+		{
+			name: "round state without time limit",
+			msg: &Message{
+				RoundStateMessage: &RoundStateMessage{
+					Width:  20,
+					Height: 10,
+					Players: []*RoundStateMessagePlayer{
+						{Name: "alice", Pieces: []Location{{0, 0}}, Direction: DirectionEast},
+						{Name: "bob", Pieces: []Location{{19, 9}}, Direction: DirectionWest},
+					},
+				},
+			},
+		},
+		{
+			name: "round state with no seconds remaining",
+			msg: &Message{
+				RoundStateMessage: &RoundStateMessage{
+					Tick:             100,
+					Width:            20,
+					Height:           10,
+					Players:          []*RoundStateMessagePlayer{},
+					SecondsRemaining: &noSeconds,
+				},
+			},
+		},
+		{
+			name: "round over",
+			msg: &Message{
+				RoundOverMessage: &RoundOverMessage{Winner: &winner},
+			},
+		},
+		{
+			name: "session",
+			msg: &Message{
+				SessionMessage: &SessionMessage{Token: "abc"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := MarshalBinaryMessage(test.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := UnmarshalBinaryMessage(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.msg) {
+				t.Errorf("got %+v, want %+v", got, test.msg)
+			}
+		})
+	}
+}
+
+func TestUnmarshalBinaryMessageErrors(t *testing.T) {
+	b, err := MarshalBinaryMessage(&Message{
+		RoundStateMessage: &RoundStateMessage{
+			Width:  20,
+			Height: 10,
+			Players: []*RoundStateMessagePlayer{
+				{Name: "alice", Pieces: []Location{{0, 0}, {1, 0}}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"unknown kind", []byte{0xff}},
+		{"invalid JSON", []byte{binaryKindJSON, '{'}},
+		{"truncated round state", b[:len(b)-3]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if msg, err := UnmarshalBinaryMessage(test.b); err == nil {
+				t.Errorf("got %+v, want an error", msg)
+			}
+		})
+	}
+}